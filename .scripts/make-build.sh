@@ -1,5 +1,7 @@
 #!/bin/bash
 
-# Building the binary that is going to be released 
-GOOS=linux GOARCH=amd64 go build -o general-service cmd/general-service/main.go  
+# Version reported by the binary, taken from the release tag when there is one
+version=$(git describe --tags --always 2>/dev/null || echo dev)
 
+# Building the binary that is going to be released, for linux/amd64 unless GOOS and GOARCH are set
+GOOS=${GOOS:-linux} GOARCH=${GOARCH:-amd64} go build -ldflags "-X github.com/sorayaormazabalmayo/general-service/internal/cli.Version=${version}" -o general-service cmd/general-service/main.go  
@@ -1,7 +1,7 @@
 #!/bin/bash
 
 # Building the binary that is going to be released 
-GOOS=linux GOARCH=amd64 go build -o general-service cmd/general-service/main.go 
+GOOS=${GOOS:-linux} GOARCH=${GOARCH:-amd64} go build -o general-service cmd/general-service/main.go 
    
 # Exit script on any error
 set -e
@@ -4,23 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/peterbourgon/ff/v4"
 	"github.com/peterbourgon/ff/v4/ffhelp"
 	"github.com/peterbourgon/ff/v4/ffyaml"
-	sdlog "github.com/saltosystems-internal/x/log/stackdriver"
 	"github.com/sorayaormazabalmayo/general-service/internal/cli"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
 	//"github.com/kardianos/minwinsvc"
 )
 
 func main() {
+	if err := run(); err != nil {
+		os.Exit(1)
+	}
+}
 
-	// Create new logger
-	logger := sdlog.New()
-
-	// Create command
-	generalServiceCmd := cli.NewGeneralServiceCommand(logger)
+func run() error {
+	// Create command, the logging and tracing flags are parsed into logCfg and traceCfg
+	var (
+		logCfg   logging.Config
+		traceCfg tracing.Config
+	)
+	generalServiceCmd := cli.NewGeneralServiceCommand(&logCfg, &traceCfg)
 
 	// Control aspects of parsing behaviour
 	opts := []ff.Option{
@@ -28,15 +36,38 @@ func main() {
 		ff.WithConfigFileParser(ffyaml.Parse),
 	}
 
-	// Run CLI command
-	if err := generalServiceCmd.ParseAndRun(context.Background(), os.Args[1:], opts...); err != nil {
-		if errors.Is(err, ff.ErrHelp) || errors.Is(err, ff.ErrDuplicateFlag) || errors.Is(err, ff.ErrAlreadyParsed) || errors.Is(err, ff.ErrUnknownFlag) || errors.Is(err, ff.ErrNotParsed) {
-			fmt.Fprintf(os.Stderr, "\n%s\n", ffhelp.Command(&generalServiceCmd))
-		}
+	// Parse the flags before creating the logger, then run the CLI command
+	if err := generalServiceCmd.Parse(os.Args[1:], opts...); err != nil {
+		return fail(&generalServiceCmd, err)
+	}
 
-		if !errors.Is(err, ff.ErrHelp) {
-			logger.Error(err)
-		}
-		os.Exit(1)
+	logger, closer, err := logging.New(logCfg)
+	if err != nil {
+		return fail(&generalServiceCmd, err)
+	}
+	defer closer.Close()
+	slog.SetDefault(logger.With(logging.Version, cli.Version))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), traceCfg, cli.Version)
+	if err != nil {
+		return fail(&generalServiceCmd, err)
+	}
+	defer shutdownTracing(context.Background())
+
+	if err := generalServiceCmd.Run(context.Background()); err != nil {
+		return fail(&generalServiceCmd, err)
+	}
+	return nil
+}
+
+// fail reports err, showing the usage when it was caused by the flags.
+func fail(cmd *ff.Command, err error) error {
+	if errors.Is(err, ff.ErrHelp) || errors.Is(err, ff.ErrDuplicateFlag) || errors.Is(err, ff.ErrAlreadyParsed) || errors.Is(err, ff.ErrUnknownFlag) || errors.Is(err, ff.ErrNotParsed) {
+		fmt.Fprintf(os.Stderr, "\n%s\n", ffhelp.Command(cmd))
+	}
+
+	if !errors.Is(err, ff.ErrHelp) {
+		slog.Error("Command failed", "error", err)
 	}
+	return err
 }
@@ -0,0 +1,150 @@
+// Package archive extracts the release artifacts. The format is detected from
+// the content, so zip, tar, tar.gz and tar.zst archives are accepted whatever
+// their file name.
+//
+// Extraction is defensive: entries and symlinks may not point outside the
+// destination, only the permission bits of the modes are kept, and the number
+// of files and the total uncompressed size are bounded to stop decompression
+// bombs.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Format is an archive format.
+type Format int
+
+const (
+	Unknown Format = iota
+	Zip
+	Tar
+	TarGzip
+	TarZstd
+)
+
+func (f Format) String() string {
+	switch f {
+	case Zip:
+		return "zip"
+	case Tar:
+		return "tar"
+	case TarGzip:
+		return "tar.gz"
+	case TarZstd:
+		return "tar.zst"
+	default:
+		return "unknown"
+	}
+}
+
+var (
+	// ErrUnknownFormat is returned for content that is not a supported archive.
+	ErrUnknownFormat = errors.New("unknown archive format")
+	// ErrIllegalPath is returned for entries or symlinks escaping the destination.
+	ErrIllegalPath = errors.New("illegal path in archive")
+	// ErrTooLarge is returned when the uncompressed content exceeds MaxSize.
+	ErrTooLarge = errors.New("archive too large")
+	// ErrTooManyFiles is returned when the archive has more than MaxFiles entries.
+	ErrTooManyFiles = errors.New("too many files in archive")
+)
+
+// Limits bounds what an archive may contain.
+type Limits struct {
+	// MaxSize is the maximum total uncompressed size of the regular files.
+	MaxSize int64
+	// MaxFiles is the maximum number of entries.
+	MaxFiles int
+}
+
+// DefaultLimits are generous for a service release.
+var DefaultLimits = Limits{MaxSize: 1 << 30, MaxFiles: 10000}
+
+var (
+	zipMagic     = []byte("PK\x03\x04")
+	zipEmpty     = []byte("PK\x05\x06")
+	gzipMagic    = []byte{0x1f, 0x8b}
+	zstdMagic    = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic     = []byte("ustar")
+	tarMagicOff  = 257
+	detectLength = 512
+)
+
+// Detect returns the format of the archive stored in path.
+func Detect(path string) (Format, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Unknown, err
+	}
+	defer f.Close()
+
+	header := make([]byte, detectLength)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Unknown, err
+	}
+	return detect(header[:n]), nil
+}
+
+func detect(header []byte) Format {
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, zipEmpty):
+		return Zip
+	case bytes.HasPrefix(header, gzipMagic):
+		return TarGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return TarZstd
+	case len(header) >= tarMagicOff+len(tarMagic) && bytes.Equal(header[tarMagicOff:tarMagicOff+len(tarMagic)], tarMagic):
+		return Tar
+	default:
+		return Unknown
+	}
+}
+
+// Extract extracts the archive stored in src into dest, creating it if needed.
+func Extract(src, dest string, limits Limits) error {
+	format, err := Detect(src)
+	if err != nil {
+		return err
+	}
+
+	x, err := newExtractor(dest, limits)
+	if err != nil {
+		return err
+	}
+
+	if format == Zip {
+		err = x.zip(src)
+	} else {
+		err = x.stream(src, format)
+	}
+	if err != nil {
+		return err
+	}
+	return x.checkLinks()
+}
+
+// stream extracts the tar archive of the given format stored in src.
+func (x *extractor) stream(src string, format Format) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch format {
+	case Tar:
+		return x.tar(bufio.NewReader(f))
+	case TarGzip:
+		return x.tarGzip(f)
+	case TarZstd:
+		return x.tarZstd(f)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, src)
+	}
+}
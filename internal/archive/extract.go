@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// extractor writes the entries of an archive below dest, enforcing the limits.
+type extractor struct {
+	dest   string
+	limits Limits
+	files  int
+	size   int64
+	// links are the symlinks extracted, checked again once every entry is
+	// extracted as later entries may change what they resolve to
+	links []string
+}
+
+func newExtractor(dest string, limits Limits) (*extractor, error) {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return nil, err
+	}
+	// resolve dest so that symlinks in its own path do not confuse the checks
+	dest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return nil, err
+	}
+	return &extractor{dest: dest, limits: limits}, nil
+}
+
+func (x *extractor) zip(src string) error {
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if err := x.zipEntry(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) zipEntry(f *zip.File) error {
+	mode := f.Mode()
+	if mode.IsDir() {
+		return x.dir(f.Name, mode)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		// the target of a symlink is the content of the entry
+		target, err := io.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		return x.symlink(f.Name, string(target))
+	case mode.IsRegular():
+		return x.file(f.Name, mode, rc)
+	default:
+		return fmt.Errorf("unsupported entry %s with mode %s", f.Name, mode)
+	}
+}
+
+func (x *extractor) tarGzip(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return x.tar(zr)
+}
+
+func (x *extractor) tarZstd(r io.Reader) error {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	return x.tar(zr)
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		mode := h.FileInfo().Mode()
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(h.Name, mode)
+		case tar.TypeReg:
+			err = x.file(h.Name, mode, tr)
+		case tar.TypeSymlink:
+			err = x.symlink(h.Name, h.Linkname)
+		case tar.TypeXGlobalHeader:
+			// PAX global headers carry no file
+		default:
+			err = fmt.Errorf("unsupported entry %s of type %q", h.Name, h.Typeflag)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// target returns where the entry name is extracted, failing if it escapes
+// dest. The parent directories are created and checked not to be symlinks
+// leading outside dest.
+func (x *extractor) target(name string) (string, error) {
+	x.files++
+	if x.limits.MaxFiles > 0 && x.files > x.limits.MaxFiles {
+		return "", fmt.Errorf("%w: more than %d", ErrTooManyFiles, x.limits.MaxFiles)
+	}
+
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if name == "" || path.IsAbs(slashed) || slices.Contains(strings.Split(slashed, "/"), "..") {
+		return "", fmt.Errorf("%w: %s", ErrIllegalPath, name)
+	}
+	clean := path.Clean(slashed)
+	if clean == "." {
+		return x.dest, nil
+	}
+	target := filepath.Join(x.dest, filepath.FromSlash(clean))
+
+	parent := filepath.Dir(target)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(parent)
+	if err != nil {
+		return "", err
+	}
+	if !x.within(resolved) {
+		return "", fmt.Errorf("%w: %s is below a symlink leading outside the destination", ErrIllegalPath, name)
+	}
+
+	// never write through an existing symlink
+	if fi, err := os.Lstat(target); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return "", err
+		}
+	}
+	return target, nil
+}
+
+// within reports whether path is dest or below it.
+func (x *extractor) within(p string) bool {
+	rel, err := filepath.Rel(x.dest, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (x *extractor) dir(name string, mode fs.FileMode) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(target, perm(mode)|0700); err != nil {
+		return err
+	}
+	return os.Chmod(target, perm(mode)|0700)
+}
+
+func (x *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm(mode))
+	if err != nil {
+		return err
+	}
+
+	// the sizes declared by the archive are not trusted, the content is counted while written
+	var src io.Reader = r
+	if x.limits.MaxSize > 0 {
+		src = io.LimitReader(r, x.limits.MaxSize-x.size+1)
+	}
+	n, err := io.Copy(f, src)
+	x.size += n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if x.limits.MaxSize > 0 && x.size > x.limits.MaxSize {
+		return fmt.Errorf("%w: more than %d bytes uncompressed", ErrTooLarge, x.limits.MaxSize)
+	}
+	return os.Chmod(target, perm(mode))
+}
+
+func (x *extractor) symlink(name, linkname string) error {
+	target, err := x.target(name)
+	if err != nil {
+		return err
+	}
+
+	// the link must resolve below dest, relative to the directory holding it
+	if linkname == "" || filepath.IsAbs(linkname) {
+		return fmt.Errorf("%w: symlink %s -> %s", ErrIllegalPath, name, linkname)
+	}
+	if !x.linkWithin(target, linkname) {
+		return fmt.Errorf("%w: symlink %s -> %s", ErrIllegalPath, name, linkname)
+	}
+
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink(linkname, target); err != nil {
+		return err
+	}
+	x.links = append(x.links, target)
+	return nil
+}
+
+// linkWithin reports whether the symlink at target pointing to linkname
+// resolves below dest. The link is resolved as the kernel does, from the
+// resolved directory holding it and following the symlinks met on the way, so
+// that ".." goes up from where a symlink leads rather than from its name.
+func (x *extractor) linkWithin(target, linkname string) bool {
+	p, err := filepath.EvalSymlinks(filepath.Dir(target))
+	if err != nil {
+		return false
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(linkname), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			p = filepath.Dir(p)
+		default:
+			p = filepath.Join(p, elem)
+			// components that do not exist yet are only checked by name
+			if fi, err := os.Lstat(p); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+				if p, err = filepath.EvalSymlinks(p); err != nil {
+					return false
+				}
+			}
+		}
+	}
+	return x.within(p)
+}
+
+// checkLinks checks again that every symlink extracted resolves below dest,
+// now that the entries they go through are all in place.
+func (x *extractor) checkLinks() error {
+	for _, link := range x.links {
+		linkname, err := os.Readlink(link)
+		if err != nil {
+			// replaced by a later entry, checked when extracted
+			continue
+		}
+		if !x.linkWithin(link, linkname) {
+			rel, _ := filepath.Rel(x.dest, link)
+			return fmt.Errorf("%w: symlink %s -> %s", ErrIllegalPath, filepath.ToSlash(rel), linkname)
+		}
+	}
+	return nil
+}
+
+// perm keeps the permission bits of mode, dropping setuid, setgid and sticky.
+func perm(mode fs.FileMode) fs.FileMode {
+	return mode.Perm()
+}
@@ -0,0 +1,243 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry is an entry of the archives built by the tests.
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	body     string
+}
+
+func dir(name string) entry        { return entry{name: name, typeflag: tar.TypeDir} }
+func file(name, body string) entry { return entry{name: name, typeflag: tar.TypeReg, body: body} }
+func symlink(name, linkname string) entry {
+	return entry{name: name, typeflag: tar.TypeSymlink, linkname: linkname}
+}
+
+// writeTar writes a tar archive of entries in a temporary directory.
+func writeTar(t *testing.T, entries ...entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.body))}
+		if e.typeflag == tar.TypeDir {
+			h.Mode = 0755
+		}
+		if e.typeflag != tar.TypeReg {
+			h.Size = 0
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil && e.typeflag == tar.TypeReg {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, "release.tar", buf.Bytes())
+}
+
+// writeZip writes a zip archive of entries in a temporary directory.
+func writeZip(t *testing.T, entries ...entry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		content := e.body
+		switch e.typeflag {
+		case tar.TypeDir:
+			h.Name = strings.TrimSuffix(e.name, "/") + "/"
+			h.SetMode(fs.ModeDir | 0755)
+		case tar.TypeSymlink:
+			h.SetMode(fs.ModeSymlink | 0777)
+			content = e.linkname
+		default:
+			h.SetMode(0755)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, "release.zip", buf.Bytes())
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// destination returns the directory to extract to, below an otherwise empty
+// parent whose content tells whether anything escaped.
+func destination(t *testing.T) (parent, dest string) {
+	t.Helper()
+	parent = t.TempDir()
+	return parent, filepath.Join(parent, "release")
+}
+
+// checkNothingEscaped fails if anything but dest was written to parent.
+func checkNothingEscaped(t *testing.T, parent string) {
+	t.Helper()
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "release" {
+			t.Errorf("%s written outside the destination", e.Name())
+		}
+	}
+}
+
+func TestExtractRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+		limits  Limits
+		want    error
+	}{
+		{
+			name:    "traversal",
+			entries: []entry{file("../escaped", "x")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "nested traversal",
+			entries: []entry{file("bin/../../escaped", "x")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "absolute path",
+			entries: []entry{file("/tmp/escaped", "x")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "symlink escaping",
+			entries: []entry{symlink("escaped", "../escaped")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "absolute symlink",
+			entries: []entry{symlink("etc", "/etc")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "chained symlinks",
+			entries: []entry{symlink("a", "."), symlink("a/b", "..")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "symlink through a symlink",
+			entries: []entry{symlink("a", "."), symlink("b", "a/..")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "symlink retargeted by a later entry",
+			entries: []entry{dir("a"), symlink("b", "a/.."), symlink("a", ".")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "file written through a symlink",
+			entries: []entry{symlink("a", "."), file("a/../escaped", "x")},
+			want:    ErrIllegalPath,
+		},
+		{
+			name:    "size bomb",
+			entries: []entry{file("a", "12345"), file("b", "123456")},
+			limits:  Limits{MaxSize: 10},
+			want:    ErrTooLarge,
+		},
+		{
+			name:    "entry count bomb",
+			entries: []entry{file("a", ""), file("b", ""), file("c", "")},
+			limits:  Limits{MaxFiles: 2},
+			want:    ErrTooManyFiles,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for format, src := range map[string]string{"tar": writeTar(t, tt.entries...), "zip": writeZip(t, tt.entries...)} {
+				parent, dest := destination(t)
+				err := Extract(src, dest, tt.limits)
+				if !errors.Is(err, tt.want) {
+					t.Errorf("%s: Extract() error = %v, want %v", format, err, tt.want)
+				}
+				checkNothingEscaped(t, parent)
+			}
+		})
+	}
+}
+
+func TestExtractRejectsHardlinks(t *testing.T) {
+	for _, linkname := range []string{"/etc/passwd", "../escaped", "bin/service"} {
+		parent, dest := destination(t)
+		src := writeTar(t, file("bin/service", "x"), entry{name: "link", typeflag: tar.TypeLink, linkname: linkname})
+		if err := Extract(src, dest, DefaultLimits); err == nil {
+			t.Errorf("hardlink to %s extracted", linkname)
+		}
+		if _, err := os.Lstat(filepath.Join(dest, "link")); err == nil {
+			t.Errorf("hardlink to %s created", linkname)
+		}
+		checkNothingEscaped(t, parent)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	entries := []entry{
+		dir("bin"),
+		file("bin/service", "binary"),
+		dir("lib"),
+		file("lib/libfoo.so.1", "library"),
+		symlink("lib/libfoo.so", "libfoo.so.1"),
+		symlink("bin/lib", "../lib"),
+		// symlinks leading below the destination may be written through
+		file("bin/lib/extra", "extra"),
+	}
+
+	for format, src := range map[string]string{"tar": writeTar(t, entries...), "zip": writeZip(t, entries...)} {
+		parent, dest := destination(t)
+		if err := Extract(src, dest, DefaultLimits); err != nil {
+			t.Fatalf("%s: Extract() error = %v", format, err)
+		}
+		for name, want := range map[string]string{
+			"bin/service":     "binary",
+			"lib/libfoo.so":   "library",
+			"bin/lib/extra":   "extra",
+			"lib/extra":       "extra",
+			"lib/libfoo.so.1": "library",
+		} {
+			got, err := os.ReadFile(filepath.Join(dest, name))
+			if err != nil || string(got) != want {
+				t.Errorf("%s: %s = %q, %v, want %q", format, name, got, err, want)
+			}
+		}
+		checkNothingEscaped(t, parent)
+	}
+}
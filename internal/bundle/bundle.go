@@ -0,0 +1,207 @@
+// Package bundle packages a copy of the TUF repository into a single archive
+// for sites without internet access, and reads it back.
+//
+// A bundle is a tar.gz holding the metadata/, targets/ and artifacts/
+// directories of a mirror (see package mirror) synced from the first root, so
+// that it carries the whole chain of roots. Nothing in a bundle is trusted: it
+// is verified with the local root.json by the same go-tuf client used online,
+// reading the files through Fetcher instead of the network.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/archive"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/fetcher"
+)
+
+// MetadataURL and TargetsURL are the repository URLs to use with the fetcher
+// of a bundle.
+const (
+	MetadataURL = baseURL + "metadata"
+	TargetsURL  = baseURL + "targets"
+)
+
+const baseURL = "bundle:///"
+
+// dirs are the directories of a mirror that go into a bundle.
+var dirs = []string{"metadata", "targets", "artifacts"}
+
+// Create writes the bundle of the mirror in dir to dest.
+func Create(dest, dir string) (err error) {
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, d := range dirs {
+		err := filepath.WalkDir(filepath.Join(dir, d), func(path string, e fs.DirEntry, err error) error {
+			if err != nil || !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			return addFile(tw, path, filepath.ToSlash(rel))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Bundle is a bundle extracted to a temporary directory.
+type Bundle struct {
+	dir string
+}
+
+// Open extracts the bundle at path. Close removes the extracted files.
+func Open(path string) (*Bundle, error) {
+	dir, err := os.MkdirTemp("", "general-service-bundle-")
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.Extract(path, dir, archive.DefaultLimits); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to extract the bundle: %w", err)
+	}
+	return &Bundle{dir: dir}, nil
+}
+
+// Close removes the extracted bundle.
+func (b *Bundle) Close() error {
+	return os.RemoveAll(b.dir)
+}
+
+// Fetcher returns a go-tuf fetcher reading MetadataURL and TargetsURL from
+// the bundle. Missing files are reported as an HTTP 404, as a repository
+// would.
+func (b *Bundle) Fetcher() fetcher.Fetcher {
+	return dirFetcher{dir: b.dir}
+}
+
+// CopyArtifact writes the artifact with SHA-256 sum and size length to dest,
+// failing if the bundle does not hold it or if its content does not match
+// both. The size is checked first, so that an oversized file is not read.
+func (b *Bundle) CopyArtifact(sum string, length int64, dest string) error {
+	sum = strings.ToLower(sum)
+	if raw, err := hex.DecodeString(sum); err != nil || len(raw) != sha256.Size {
+		return fmt.Errorf("invalid hash %q", sum)
+	}
+	in, err := os.Open(filepath.Join(b.dir, "artifacts", sum))
+	if err != nil {
+		return fmt.Errorf("artifact %s not in the bundle: %w", sum, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != length {
+		return fmt.Errorf("artifact size mismatch: got %d bytes, expected %d", info.Size(), length)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".bundle-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	// the file may still grow while it is copied
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(in, length+1))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if n != length {
+		return fmt.Errorf("artifact size mismatch: got %d bytes, expected %d", n, length)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("artifact hash mismatch: got %s, expected %s", got, sum)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+type dirFetcher struct {
+	dir string
+}
+
+func (f dirFetcher) DownloadFile(urlPath string, maxLength int64, _ time.Duration) ([]byte, error) {
+	notFound := &metadata.ErrDownloadHTTP{StatusCode: http.StatusNotFound, URL: urlPath}
+
+	rel, ok := strings.CutPrefix(urlPath, baseURL)
+	if !ok {
+		return nil, notFound
+	}
+	rel, err := url.PathUnescape(rel)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil, notFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(f.dir, filepath.FromSlash(rel)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, notFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxLength {
+		return nil, &metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("%s is larger than expected %d", urlPath, maxLength)}
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+// Package channel maps release channels (stable, beta, canary...) to the
+// target paths and delegated roles of the TUF repository.
+package channel
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Stable is the default channel. Its index keeps the historical target path
+// <service>/<service>-index.json.
+const Stable = "stable"
+
+var nameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// Validate checks that name can be used as a channel name.
+func Validate(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid channel name %q", name)
+	}
+	return nil
+}
+
+// IndexPath returns the TUF target path of the index of service in channel.
+func IndexPath(service, channel string) string {
+	if channel == "" || channel == Stable {
+		return path.Join(service, fmt.Sprintf("%s-index.json", service))
+	}
+	return path.Join(service, "channels", fmt.Sprintf("%s-index.json", channel))
+}
+
+// Roles holds the delegated role expected to sign the index of each channel.
+type Roles map[string]string
+
+// ParseRoles parses a list of "<channel>=<role>" entries.
+func ParseRoles(entries []string) (Roles, error) {
+	roles := make(Roles)
+	for _, entry := range entries {
+		channel, role, ok := strings.Cut(entry, "=")
+		if !ok || role == "" {
+			return nil, fmt.Errorf("invalid channel role %q, expected <channel>=<role>", entry)
+		}
+		if err := Validate(channel); err != nil {
+			return nil, err
+		}
+		roles[channel] = role
+	}
+	return roles, nil
+}
+
+// For returns the role expected to sign the index of channel. By default the
+// stable channel can be signed by any trusted role, as resolved by the TUF
+// delegations, and any other channel by the role named after it.
+func (r Roles) For(channel string) string {
+	if role, ok := r[channel]; ok {
+		return role
+	}
+	if channel == "" || channel == Stable {
+		return ""
+	}
+	return channel
+}
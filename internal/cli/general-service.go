@@ -2,20 +2,56 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
 	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/peterbourgon/ff/v4"
-	"github.com/saltosystems-internal/x/log"
+	"github.com/sorayaormazabalmayo/general-service/internal/bundle"
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/httpclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/mirror"
+	"github.com/sorayaormazabalmayo/general-service/internal/p2p"
+	"github.com/sorayaormazabalmayo/general-service/internal/pin"
 	"github.com/sorayaormazabalmayo/general-service/internal/server"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
 	"github.com/sorayaormazabalmayo/general-service/internal/updater"
 )
 
-// NewGeneralServiceCommand creates and returns the root CLI command.
-func NewGeneralServiceCommand(logger log.Logger) ff.Command {
+// Version is the running version of general-service. It is set at build time
+// with -ldflags "-X github.com/sorayaormazabalmayo/general-service/internal/cli.Version=<tag>".
+var Version = "dev"
+
+// NewGeneralServiceCommand creates and returns the root CLI command. The logging
+// and tracing flags, available to every subcommand, are parsed into logCfg and
+// traceCfg. The commands log through slog.Default and trace through the global
+// tracer provider, which must be set up from them once parsed.
+func NewGeneralServiceCommand(logCfg *logging.Config, traceCfg *tracing.Config) ff.Command {
 	fs := ff.NewFlagSet("general-service")
+	fs.StringVar(&logCfg.Level, 0, "log-level", "info", "minimum level logged: debug, info, warn or error")
+	fs.StringVar(&logCfg.Format, 0, "log-format", logging.FormatText, "log format: text or json")
+	fs.StringVar(&logCfg.Path, 0, "log-file", "", "file the logs are written to besides stdout")
+	fs.StringVar(&logCfg.Output, 0, "log-output", logging.OutputStdout, "where the logs are written: stdout (and the log file), journald, or auto to use journald under systemd")
+	fs.StringVar(&logCfg.Rotation.MaxSize, 0, "log-max-size", "10M", "size past which the log file is rotated, e.g. 10M, empty for no limit")
+	fs.DurationVar(&logCfg.Rotation.MaxAge, 0, "log-max-age", 24*time.Hour, "time after which the log file is rotated, 0 for no limit")
+	fs.IntVar(&logCfg.Rotation.MaxBackups, 0, "log-max-backups", 7, "rotated log files kept, 0 to keep all of them")
+	fs.BoolVarDefault(&logCfg.Rotation.Compress, 0, "log-compress", true, "gzip the rotated log files")
+	fs.StringVar(&traceCfg.Endpoint, 0, "otlp-endpoint", "", "<host>:<port> of the OTLP gRPC collector the traces are exported to, empty for no tracing")
+	fs.BoolVar(&traceCfg.Insecure, 0, "otlp-insecure", "connect to the OTLP collector without TLS")
 
-	return ff.Command{
+	cmd := ff.Command{
 		Name:      "general-service",
 		ShortHelp: "This is the root command for the general-service",
 		Usage:     "general-service [FLAGS] <SUBCOMMANDS> ...",
@@ -24,20 +60,32 @@ func NewGeneralServiceCommand(logger log.Logger) ff.Command {
 			return flag.ErrHelp
 		},
 		Subcommands: []*ff.Command{
-			newServeCommand(logger),
+			newServeCommand(),
 			newUpdateCommand(),
-			newServeAndUpdateCommand(logger),
+			newServeAndUpdateCommand(),
+			newCheckCommand(),
+			newChannelCommand(),
+			newPinCommand(),
+			newBlockCommand(),
+			newRollbackCommand(),
+			newApplyCommand(),
+			newHistoryCommand(),
+			newMirrorCommand(),
+			newExportBundleCommand(),
+			newImportBundleCommand(),
 		},
 	}
+	for _, sub := range cmd.Subcommands {
+		sub.Flags.(*ff.FlagSet).SetParent(fs)
+	}
+	return cmd
 }
 
 // newServeCommand returns a usable ff.Command for the serve subcommand.
-func newServeCommand(logger log.Logger) *ff.Command {
+func newServeCommand() *ff.Command {
 	// Configuration structure
 	cfg := &server.Config{}
 
-	logger.Info("Config parameters before parsing: ", "httpAddr:", cfg.HTTPAddr, "internal-httpAddr:", cfg.InternatHTTPAddr, "debug:", cfg.Debug)
-
 	fs := ff.NewFlagSet("serve")
 	_ = fs.String(0, "config", "", "config file in yaml format")
 	fs.StringVar(&cfg.HTTPAddr, 0, "http-addr", "localhost:8000", "HTTP address")
@@ -52,11 +100,10 @@ func newServeCommand(logger log.Logger) *ff.Command {
 		Flags:     fs,
 		Exec: func(_ context.Context, args []string) error {
 			if cfg.Debug {
-				if err := logger.SetAllowedLevel(log.AllowDebug()); err != nil {
-					return err
-				}
+				logging.SetLevel(slog.LevelDebug)
 			}
 
+			logger := slog.Default().With(logging.Phase, logging.PhaseServe)
 			logger.Info("General server started",
 				"http-addr", cfg.HTTPAddr,
 				"http-internal-addr", cfg.InternatHTTPAddr,
@@ -80,22 +127,485 @@ func newServeCommand(logger log.Logger) *ff.Command {
 
 // newUpdateCommand sets the updater.
 func newUpdateCommand() *ff.Command {
+	updaterCfg := &updater.Config{Version: Version}
+
 	// Create a flag set for the "update" subcommand.
 	fs := ff.NewFlagSet("update")
+	_ = fs.String(0, "config", "", "config file in yaml format")
+	addUpdaterFlags(fs, updaterCfg)
+
 	return &ff.Command{
 		Name:      "update",
 		ShortHelp: "Run the updater",
 		Flags:     fs,
 		Exec: func(ctx context.Context, args []string) error {
-			return updater.Run()
+			return updater.Run(*updaterCfg)
+		},
+	}
+}
+
+// addUpdaterFlags declares the flags configuring the updater.
+func addUpdaterFlags(fs *ff.FlagSet, cfg *updater.Config) {
+	fs.StringVar(&cfg.MetadataURL, 0, "metadata-url", updater.DefaultMetadataURL, "TUF metadata URL, of the repository or of a mirror")
+	fs.StringVar(&cfg.TargetsURL, 0, "targets-url", updater.DefaultTargetsURL, "TUF targets URL, of the repository or of a mirror")
+	fs.StringVar(&cfg.HTTP.ProxyURL, 0, "http-proxy", "", "HTTPS proxy URL, HTTPS_PROXY is used when empty")
+	fs.StringVar(&cfg.HTTP.CAFile, 0, "ca-bundle", "", "PEM file with extra trusted CA certificates")
+	fs.StringVar(&cfg.HTTP.CertFile, 0, "client-cert", "", "PEM client certificate for mutual TLS")
+	fs.StringVar(&cfg.HTTP.KeyFile, 0, "client-key", "", "PEM client key for mutual TLS")
+	fs.DurationVar(&cfg.HTTP.ConnectTimeout, 0, "http-connect-timeout", 10*time.Second, "HTTP connect timeout")
+	fs.DurationVar(&cfg.HTTP.ReadTimeout, 0, "http-read-timeout", 30*time.Second, "HTTP response timeout")
+	fs.DurationVar(&cfg.Schedule.Interval, 0, "check-interval", 60*time.Second, "base interval between update checks")
+	fs.DurationVar(&cfg.Schedule.Splay, 0, "check-splay", 30*time.Second, "maximum random delay added to every check interval")
+	fs.DurationVar(&cfg.RefreshInterval, 0, "metadata-refresh-interval", 15*time.Minute, "maximum time between two refreshes of the TUF metadata, checks requested from the UI or the CLI always refresh")
+	fs.StringListVar(&cfg.Schedule.Windows, 0, "check-window", "cron-style window in which checks are allowed, e.g. \"* 2-4 * * *\" (repeatable)")
+	fs.StringVar(&cfg.Channel, 0, "channel", channel.Stable, "release channel followed unless another one is selected from the UI or the CLI")
+	fs.StringListVar(&cfg.ChannelRoles, 0, "channel-role", "<channel>=<role> delegated role expected to sign the index of a channel (repeatable)")
+	fs.StringVar(&cfg.DownloadRate, 0, "download-rate", "", "maximum download bytes per second, e.g. 512K, empty for no limit")
+	fs.StringVar(&cfg.DownloadBurst, 0, "download-burst", "", "bytes that may be downloaded at once above the rate, e.g. 64K")
+	fs.StringListVar(&cfg.DownloadRateWindows, 0, "download-rate-window", "<HH:MM>-<HH:MM>=<rate> download rate at some times of the day, 0 for no limit (repeatable)")
+	fs.StringVar(&cfg.ArtifactVariant, 0, "artifact-variant", "", "variant of the artifacts preferred for this host, the default build is used when there is none")
+	fs.StringVar(&cfg.Pin, 0, "pin-version", "", "version or range, e.g. \">=v2025.03.01 <v2025.04.01\", the service is held at")
+	fs.StringListVar(&cfg.Blocklist, 0, "block-version", "version that must never be installed (repeatable)")
+	fs.StringListVar(&cfg.Policies, 0, "update-policy", "[service=]manual|immediate|soak:<delay>|window:<days> <HH:MM>-<HH:MM> [<timezone>] (repeatable)")
+	fs.StringListVar(&cfg.WebhookURLs, 0, "webhook-url", "URL called when an update is available (repeatable)")
+	fs.StringVar(&cfg.WebhookSecretFile, 0, "webhook-secret-file", "", "file with the secret signing the webhook calls with HMAC-SHA256, empty for unsigned calls")
+}
+
+// newCheckCommand asks a running updater to check for updates right away.
+func newCheckCommand() *ff.Command {
+	fs := ff.NewFlagSet("check")
+	statusFile := fs.String(0, "status-file", status.DefaultPath, "update status file shared with the updater")
+
+	return &ff.Command{
+		Name:      "check",
+		ShortHelp: "Ask the running updater to check for updates now",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return status.Update(*statusFile, func(s *status.Status) {
+				s.CheckRequested = 1
+			})
+		},
+	}
+}
+
+// newChannelCommand shows or switches the release channel followed by the updater.
+func newChannelCommand() *ff.Command {
+	fs := ff.NewFlagSet("channel")
+	statusFile := fs.String(0, "status-file", status.DefaultPath, "update status file shared with the updater")
+
+	return &ff.Command{
+		Name:      "channel",
+		Usage:     "general-service channel [FLAGS] [<channel>]",
+		ShortHelp: "Show or switch the release channel",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				s, err := status.Read(*statusFile)
+				if err != nil {
+					return err
+				}
+				if s.Channel == "" {
+					fmt.Println("default channel of the updater")
+					return nil
+				}
+				fmt.Println(s.Channel)
+				return nil
+			}
+
+			if err := channel.Validate(args[0]); err != nil {
+				return err
+			}
+			// check right away so that the switch does not wait for the next check
+			return status.Update(*statusFile, func(s *status.Status) {
+				s.Channel = args[0]
+				s.CheckRequested = 1
+			})
+		},
+	}
+}
+
+// newPinCommand shows, sets or clears the version the updater holds the service at.
+func newPinCommand() *ff.Command {
+	fs := ff.NewFlagSet("pin")
+	statusFile := fs.String(0, "status-file", status.DefaultPath, "update status file shared with the updater")
+	clearPin := fs.BoolDefault(0, "clear", false, "remove the pin, going back to the one configured in the updater")
+
+	return &ff.Command{
+		Name:      "pin",
+		Usage:     "general-service pin [FLAGS] [<version>|\"<range>\"]",
+		ShortHelp: "Show, set or clear the version pin",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 && !*clearPin {
+				s, err := status.Read(*statusFile)
+				if err != nil {
+					return err
+				}
+				switch {
+				case s.ActivePin != "":
+					fmt.Println(s.ActivePin)
+				case s.Pin != "":
+					fmt.Println(s.Pin)
+				default:
+					fmt.Println("not pinned")
+				}
+				if s.HeldBack != "" {
+					fmt.Println("held back:", s.HeldBack)
+				}
+				return nil
+			}
+
+			spec := strings.Join(args, " ")
+			if *clearPin {
+				spec = ""
+			}
+			if _, err := pin.Parse(spec); err != nil {
+				return err
+			}
+			// check right away so that the available update is evaluated against the new pin
+			return status.Update(*statusFile, func(s *status.Status) {
+				s.Pin = spec
+				s.CheckRequested = 1
+			})
+		},
+	}
+}
+
+// newBlockCommand lists, adds or removes blocklisted versions.
+func newBlockCommand() *ff.Command {
+	fs := ff.NewFlagSet("block")
+	statusFile := fs.String(0, "status-file", status.DefaultPath, "update status file shared with the updater")
+	remove := fs.BoolDefault(0, "remove", false, "remove the versions from the blocklist")
+
+	return &ff.Command{
+		Name:      "block",
+		Usage:     "general-service block [FLAGS] [<version> ...]",
+		ShortHelp: "List, add or remove blocklisted versions",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) == 0 {
+				s, err := status.Read(*statusFile)
+				if err != nil {
+					return err
+				}
+				for _, v := range s.Blocklist {
+					fmt.Println(v)
+				}
+				return nil
+			}
+
+			if _, err := pin.NewRules("", args); err != nil {
+				return err
+			}
+			return status.Update(*statusFile, func(s *status.Status) {
+				var blocklist []string
+				for _, v := range s.Blocklist {
+					if !slices.Contains(args, v) {
+						blocklist = append(blocklist, v)
+					}
+				}
+				if !*remove {
+					blocklist = append(blocklist, args...)
+				}
+				s.Blocklist = blocklist
+				s.CheckRequested = 1
+			})
+		},
+	}
+}
+
+// newRollbackCommand allows the updater to install a version older than the installed one, e.g. after
+// a release is withdrawn and the index points back to the previous one.
+func newRollbackCommand() *ff.Command {
+	fs := ff.NewFlagSet("rollback")
+	statusFile := fs.String(0, "status-file", status.DefaultPath, "update status file shared with the updater")
+	cancel := fs.BoolDefault(0, "cancel", false, "refuse downgrades again")
+
+	return &ff.Command{
+		Name:      "rollback",
+		ShortHelp: "Allow the next update to install an older version",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			return status.Update(*statusFile, func(s *status.Status) {
+				s.Rollback = !*cancel
+				s.CheckRequested = 1
+			})
+		},
+	}
+}
+
+// newApplyCommand asks a running updater to install the available update, recording the user who asked.
+func newApplyCommand() *ff.Command {
+	fs := ff.NewFlagSet("apply")
+	statusFile := fs.String(0, "status-file", status.DefaultPath, "update status file shared with the updater")
+
+	return &ff.Command{
+		Name:      "apply",
+		ShortHelp: "Ask the running updater to install the available update now",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			s, err := status.Read(*statusFile)
+			if err != nil {
+				return err
+			}
+			if s.UpdateAvailable != 1 {
+				return errors.New("no update available")
+			}
+			return status.Update(*statusFile, func(s *status.Status) {
+				s.UpdateRequested = 1
+				s.RequestSource = history.SourceCLI
+				s.RequestUser = currentUser()
+				s.RequestTrace = ""
+			})
+		},
+	}
+}
+
+// currentUser returns the user running the CLI, the one who ran sudo if it was used.
+func currentUser() string {
+	if name := os.Getenv("SUDO_USER"); name != "" {
+		return name
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return ""
+}
+
+// newHistoryCommand lists the update history recorded by the updater.
+func newHistoryCommand() *ff.Command {
+	fs := ff.NewFlagSet("history")
+	historyFile := fs.String(0, "history-file", history.DefaultPath, "update history file written by the updater")
+	limit := fs.Int(0, "limit", 20, "number of entries listed, newest first, 0 for all of them")
+	event := fs.String(0, "event", "", "list only the entries of an event, e.g. activate")
+	asJSON := fs.BoolDefault(0, "json", false, "list the entries as JSON lines")
+
+	verify := newHistoryVerifyCommand(historyFile)
+	verify.Flags.(*ff.FlagSet).SetParent(fs)
+
+	return &ff.Command{
+		Name:        "history",
+		Usage:       "general-service history [FLAGS] [verify]",
+		ShortHelp:   "List the update history: checks, requests, downloads and activations",
+		Flags:       fs,
+		Subcommands: []*ff.Command{verify},
+		Exec: func(ctx context.Context, args []string) error {
+			entries, err := history.Read(*historyFile)
+			if err != nil {
+				return err
+			}
+			entries = history.Recent(history.Filter(entries, *event), *limit)
+
+			if *asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				for _, e := range entries {
+					if err := enc.Encode(e); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "TIME\tEVENT\tVERSION\tSOURCE\tOUTCOME\tDURATION\tDETAIL")
+			for _, e := range entries {
+				source := e.Source
+				if e.User != "" {
+					source += " (" + e.User + ")"
+				}
+				detail := e.Detail
+				if e.Error != "" {
+					detail = strings.TrimSpace(detail + " " + e.Error)
+				}
+				version := e.Version
+				if e.From != "" {
+					version = e.From + " -> " + e.Version
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Event, version, source, e.Outcome, e.Duration(), detail)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// newHistoryVerifyCommand checks that the entries of the update history have not been edited, inserted or
+// removed, following their hash chain.
+func newHistoryVerifyCommand(historyFile *string) *ff.Command {
+	fs := ff.NewFlagSet("verify")
+	head := fs.String(0, "head", "", "hash of the last entry printed by the previous verification, to detect the last entries removed")
+
+	return &ff.Command{
+		Name:      "verify",
+		Usage:     "general-service history verify [FLAGS]",
+		ShortHelp: "Verify the hash chain of the update history",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			report, err := history.Verify(*historyFile, *head)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%d entries verified\n", report.Entries)
+			// removing the last entries does not break the chain: the hash of the last one is to be noted away
+			// from the host and passed with --head to the next verification
+			if report.Head != "" {
+				fmt.Println("last entry:", report.Head)
+			}
+			return nil
+		},
+	}
+}
+
+// newMirrorCommand runs a read-only cache of the TUF repository that the
+// updaters of a site can use as their metadata and targets URLs.
+func newMirrorCommand() *ff.Command {
+	cfg := mirror.Config{}
+	httpCfg := httpclient.Config{UserAgent: httpclient.UserAgent(Version)}
+	var tokenPath string
+
+	fs := ff.NewFlagSet("mirror")
+	_ = fs.String(0, "config", "", "config file in yaml format")
+	fs.StringVar(&cfg.Addr, 0, "addr", ":8080", "address where the mirrored metadata, targets and artifacts are served")
+	fs.StringVar(&cfg.Dir, 0, "dir", "mirror", "directory holding the trusted metadata and the mirrored content")
+	fs.StringVar(&tokenPath, 0, "token-file", "", "file holding the token shared by the peers of the site, required to serve the artifacts")
+	fs.StringVar(&cfg.MetadataURL, 0, "metadata-url", updater.DefaultMetadataURL, "TUF metadata URL of the origin repository")
+	fs.StringVar(&cfg.TargetsURL, 0, "targets-url", updater.DefaultTargetsURL, "TUF targets URL of the origin repository")
+	fs.StringVar(&cfg.Root, 0, "root", "", "root.json to trust, the first root of the origin is trusted on first use when empty")
+	fs.StringListVar(&cfg.Services, 0, "service", "service whose index and artifacts are mirrored (repeatable)")
+	fs.StringListVar(&cfg.Channels, 0, "channel", "release channel mirrored, stable when none is given (repeatable)")
+	fs.DurationVar(&cfg.Interval, 0, "interval", 5*time.Minute, "interval between two syncs with the origin")
+	fs.IntVar(&cfg.MaxArtifacts, 0, "max-artifacts", 50, "number of artifacts kept")
+	fs.StringVar(&cfg.ServiceAccountKey, 0, "service-account-key", "", "Google service account key used to download the artifacts")
+	fs.StringVar(&httpCfg.ProxyURL, 0, "http-proxy", "", "HTTPS proxy URL, HTTPS_PROXY is used when empty")
+	fs.StringVar(&httpCfg.CAFile, 0, "ca-bundle", "", "PEM file with extra trusted CA certificates")
+	fs.DurationVar(&httpCfg.ConnectTimeout, 0, "http-connect-timeout", 10*time.Second, "HTTP connect timeout")
+	fs.DurationVar(&httpCfg.ReadTimeout, 0, "http-read-timeout", 30*time.Second, "HTTP response timeout")
+
+	return &ff.Command{
+		Name:      "mirror",
+		ShortHelp: "Serve a verified cache of the TUF metadata, targets and artifacts for a site",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(cfg.Services) == 0 {
+				cfg.Services = []string{"general-service"}
+			}
+			for _, ch := range cfg.Channels {
+				if err := channel.Validate(ch); err != nil {
+					return err
+				}
+			}
+			token, err := p2p.ReadToken(tokenPath)
+			if err != nil {
+				return err
+			}
+			cfg.Token = token
+
+			client, err := httpclient.New(httpCfg)
+			if err != nil {
+				return err
+			}
+			cfg.HTTPClient = client
+
+			m, err := mirror.New(cfg, slog.Default())
+			if err != nil {
+				return err
+			}
+			return m.Run(ctx)
+		},
+	}
+}
+
+// newExportBundleCommand syncs a copy of the TUF repository and packages it
+// into a bundle for the sites without internet access.
+func newExportBundleCommand() *ff.Command {
+	cfg := mirror.Config{}
+	httpCfg := httpclient.Config{UserAgent: httpclient.UserAgent(Version)}
+
+	fs := ff.NewFlagSet("export-bundle")
+	_ = fs.String(0, "config", "", "config file in yaml format")
+	fs.StringVar(&cfg.MetadataURL, 0, "metadata-url", updater.DefaultMetadataURL, "TUF metadata URL of the origin repository")
+	fs.StringVar(&cfg.TargetsURL, 0, "targets-url", updater.DefaultTargetsURL, "TUF targets URL of the origin repository")
+	fs.StringListVar(&cfg.Services, 0, "service", "service whose index and artifacts are exported (repeatable)")
+	fs.StringListVar(&cfg.Channels, 0, "channel", "release channel exported, stable when none is given (repeatable)")
+	fs.StringVar(&cfg.ServiceAccountKey, 0, "service-account-key", "", "Google service account key used to download the artifacts")
+	fs.StringVar(&httpCfg.ProxyURL, 0, "http-proxy", "", "HTTPS proxy URL, HTTPS_PROXY is used when empty")
+	fs.StringVar(&httpCfg.CAFile, 0, "ca-bundle", "", "PEM file with extra trusted CA certificates")
+	fs.DurationVar(&httpCfg.ConnectTimeout, 0, "http-connect-timeout", 10*time.Second, "HTTP connect timeout")
+	fs.DurationVar(&httpCfg.ReadTimeout, 0, "http-read-timeout", 30*time.Second, "HTTP response timeout")
+
+	return &ff.Command{
+		Name:      "export-bundle",
+		Usage:     "general-service export-bundle [FLAGS] <bundle.tar.gz>",
+		ShortHelp: "Package the TUF metadata, indexes and artifacts into a bundle for offline sites",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return flag.ErrHelp
+			}
+			if len(cfg.Services) == 0 {
+				cfg.Services = []string{"general-service"}
+			}
+			for _, ch := range cfg.Channels {
+				if err := channel.Validate(ch); err != nil {
+					return err
+				}
+			}
+
+			client, err := httpclient.New(httpCfg)
+			if err != nil {
+				return err
+			}
+			cfg.HTTPClient = client
+
+			// a new mirror starts from the first root, so the bundle carries the whole chain of roots
+			dir, err := os.MkdirTemp("", "general-service-export-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+			cfg.Dir = dir
+
+			m, err := mirror.New(cfg, slog.Default())
+			if err != nil {
+				return err
+			}
+			if err := m.Sync(ctx); err != nil {
+				return err
+			}
+			return bundle.Create(args[0], dir)
+		},
+	}
+}
+
+// newImportBundleCommand verifies a bundle with the trusted root and offers the
+// updates it holds, ready to install.
+func newImportBundleCommand() *ff.Command {
+	updaterCfg := &updater.Config{Version: Version}
+
+	fs := ff.NewFlagSet("import-bundle")
+	_ = fs.String(0, "config", "", "config file in yaml format")
+	acceptExpired := fs.Duration(0, "accept-expired", 0, "approve importing a bundle whose metadata expired at most this long ago")
+	agentDir := fs.String(0, "agent-dir", status.DefaultDir, "directory of the updater agent the bundle is imported into")
+	addUpdaterFlags(fs, updaterCfg)
+
+	return &ff.Command{
+		Name:      "import-bundle",
+		Usage:     "general-service import-bundle [FLAGS] <bundle.tar.gz>",
+		ShortHelp: "Verify an offline bundle and offer the updates it holds",
+		Flags:     fs,
+		Exec: func(ctx context.Context, args []string) error {
+			if len(args) != 1 {
+				return flag.ErrHelp
+			}
+			return updater.ImportBundle(*updaterCfg, *agentDir, args[0], *acceptExpired)
 		},
 	}
 }
 
 // newServeAndUpdateCommand runs both serve and update concurrently.
-func newServeAndUpdateCommand(logger log.Logger) *ff.Command {
+func newServeAndUpdateCommand() *ff.Command {
 	// Create a configuration structure that will be populated from the flags.
 	cfg := &server.Config{}
+	updaterCfg := &updater.Config{Version: Version}
 
 	// Create the flag set and declare all flags here.
 	fs := ff.NewFlagSet("serve-and-update")
@@ -103,25 +613,28 @@ func newServeAndUpdateCommand(logger log.Logger) *ff.Command {
 	fs.StringVar(&cfg.HTTPAddr, 0, "http-addr", "localhost:8000", "HTTP address")
 	fs.StringVar(&cfg.InternatHTTPAddr, 0, "internal-http-addr", "localhost:9000", "Internal HTTP address")
 	fs.BoolVarDefault(&cfg.Debug, 0, "debug", false, "Enable debug")
-	fs.BoolVarDefault(&cfg.AutoUpdate, 0, "auto-update", false, "Enable updater")
-	fs.StringVar(&cfg.MetadataURL, 0, "metadata-url", "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/metadata", "Metadata URL")
+	fs.BoolVarDefault(&cfg.AutoUpdate, 0, "auto-update", false, "Apply updates automatically, as the immediate update policy")
+	addUpdaterFlags(fs, updaterCfg)
 
 	cmd := &ff.Command{
 		Name:      "serve-and-update",
 		ShortHelp: "Run both serve and update concurrently",
 		Flags:     fs,
 		Exec: func(ctx context.Context, args []string) error {
+			// metadata-url is shared with the updater
+			cfg.MetadataURL = updaterCfg.MetadataURL
+
+			if cfg.Debug {
+				logging.SetLevel(slog.LevelDebug)
+			}
+			logger := slog.Default()
+
 			var wg sync.WaitGroup
 			wg.Add(2)
 
 			// Launch the server using the parsed config.
 			go func() {
 				defer wg.Done()
-				if cfg.Debug {
-					if err := logger.SetAllowedLevel(log.AllowDebug()); err != nil {
-						logger.Error("failed to set debug level", "error", err)
-					}
-				}
 				s, err := server.NewServer(cfg, logger)
 				if err != nil {
 					logger.Error("failed to create server", "error", err)
@@ -132,10 +645,15 @@ func newServeAndUpdateCommand(logger log.Logger) *ff.Command {
 				}
 			}()
 
+			// auto-update applies the updates immediately unless an update policy says otherwise
+			if cfg.AutoUpdate {
+				updaterCfg.Policies = append([]string{"immediate"}, updaterCfg.Policies...)
+			}
+
 			// Launch the updater.
 			go func() {
 				defer wg.Done()
-				if err := updater.Run(); err != nil {
+				if err := updater.Run(*updaterCfg); err != nil {
 					logger.Error("update command error", "error", err)
 				}
 			}()
@@ -0,0 +1,152 @@
+// Package delta rebuilds the artifact of a release from a delta and the
+// artifact of the installed previous release. A delta copies the ranges of the
+// installed artifact that did not change and inserts the new bytes, so only
+// these are downloaded. The rebuilt artifact is verified against the size and
+// the hash of the full artifact, so a delta can never produce something
+// different from it.
+//
+// A delta starts with Magic and goes on with instructions, each an opcode
+// followed by big-endian uint64 operands:
+//
+//	'C' offset length   copy length bytes of the installed artifact from offset
+//	'I' length data     insert the length bytes of data
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Magic starts every delta.
+const Magic = "GSDELTA1"
+
+// Opcodes of the instructions.
+const (
+	OpCopy   = 'C'
+	OpInsert = 'I'
+)
+
+var (
+	// ErrInvalid is returned for deltas that cannot be parsed.
+	ErrInvalid = errors.New("invalid delta")
+	// ErrMismatch is returned when the rebuilt artifact does not match the
+	// size or the hash of the full artifact.
+	ErrMismatch = errors.New("delta does not rebuild the artifact")
+)
+
+// Apply rebuilds the artifact of length bytes and SHA-256 sum from the delta
+// at deltaPath and the installed artifact at basePath, and writes it to dest.
+// No more than length bytes are written, and dest is removed unless the
+// rebuilt artifact matches.
+func Apply(deltaPath, basePath, dest string, length int64, sum string) (err error) {
+	d, err := os.Open(deltaPath)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	base, err := os.Open(basePath)
+	if err != nil {
+		return fmt.Errorf("installed artifact missing: %w", err)
+	}
+	defer base.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(dest)
+		}
+	}()
+
+	h := sha256.New()
+	w := &limitedWriter{w: io.MultiWriter(out, h), n: length}
+	if err := apply(bufio.NewReader(d), base, w); err != nil {
+		return err
+	}
+	if w.n != 0 {
+		return fmt.Errorf("%w: got %d bytes, expected %d", ErrMismatch, length-w.n, length)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, sum) {
+		return fmt.Errorf("%w: hash %s, expected %s", ErrMismatch, got, sum)
+	}
+	return nil
+}
+
+// apply runs the instructions of the delta r on base, writing to w.
+func apply(r *bufio.Reader, base io.ReaderAt, w io.Writer) error {
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != Magic {
+		return fmt.Errorf("%w: bad magic", ErrInvalid)
+	}
+
+	for {
+		op, err := r.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case OpCopy:
+			var operands struct{ Offset, Length uint64 }
+			if err := binary.Read(r, binary.BigEndian, &operands); err != nil {
+				return fmt.Errorf("%w: truncated copy: %v", ErrInvalid, err)
+			}
+			if operands.Offset > 1<<62 || operands.Length > 1<<62 {
+				return fmt.Errorf("%w: copy out of range", ErrInvalid)
+			}
+			n, err := io.Copy(w, io.NewSectionReader(base, int64(operands.Offset), int64(operands.Length)))
+			if err != nil {
+				return err
+			}
+			if n != int64(operands.Length) {
+				return fmt.Errorf("%w: copy past the end of the installed artifact", ErrMismatch)
+			}
+		case OpInsert:
+			var length uint64
+			if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+				return fmt.Errorf("%w: truncated insert: %v", ErrInvalid, err)
+			}
+			if length > 1<<62 {
+				return fmt.Errorf("%w: insert out of range", ErrInvalid)
+			}
+			if _, err := io.CopyN(w, r, int64(length)); err != nil {
+				if err == io.EOF {
+					return fmt.Errorf("%w: truncated insert", ErrInvalid)
+				}
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: unknown opcode %q", ErrInvalid, op)
+		}
+	}
+}
+
+// limitedWriter fails the writes past n bytes.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, fmt.Errorf("%w: larger than expected", ErrMismatch)
+	}
+	n, err := l.w.Write(p)
+	l.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,124 @@
+package delta
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const base = "the installed release of the service"
+
+// instructions builds a delta from the instructions, each a copy of an
+// [offset, length] range of the base or an insertion of a string.
+func instructions(ops ...any) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(Magic)
+	for _, op := range ops {
+		switch op := op.(type) {
+		case [2]uint64:
+			buf.WriteByte(OpCopy)
+			binary.Write(&buf, binary.BigEndian, op)
+		case string:
+			buf.WriteByte(OpInsert)
+			binary.Write(&buf, binary.BigEndian, uint64(len(op)))
+			buf.WriteString(op)
+		}
+	}
+	return buf.Bytes()
+}
+
+func sum(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// rebuild writes the base and the delta to a temporary directory and applies it.
+func rebuild(t *testing.T, delta []byte, want string) (string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base.zip")
+	deltaPath := filepath.Join(dir, "delta")
+	if err := os.WriteFile(basePath, []byte(base), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(deltaPath, delta, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "rebuilt.zip")
+	return dest, Apply(deltaPath, basePath, dest, int64(len(want)), sum(want))
+}
+
+func TestApply(t *testing.T) {
+	// "the installed release of the service" to "the new release of the service, patched"
+	want := "the new release of the service, patched"
+	dest, err := rebuild(t, instructions([2]uint64{0, 4}, "new", [2]uint64{13, 23}, ", patched"), want)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got, err := os.ReadFile(dest)
+	if err != nil || string(got) != want {
+		t.Errorf("rebuilt %q, %v, want %q", got, err, want)
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	want := "the new release of the service, patched"
+	tests := []struct {
+		name  string
+		delta []byte
+		want  error
+	}{
+		{
+			name:  "bad magic",
+			delta: append([]byte("GSDELTA0"), instructions("x")[len(Magic):]...),
+			want:  ErrInvalid,
+		},
+		{
+			name:  "unknown opcode",
+			delta: append(instructions([2]uint64{0, 4}), 'X'),
+			want:  ErrInvalid,
+		},
+		{
+			name:  "truncated insert",
+			delta: instructions("new")[:len(Magic)+1+8+1],
+			want:  ErrInvalid,
+		},
+		{
+			name:  "copy past the base",
+			delta: instructions([2]uint64{30, 20}),
+			want:  ErrMismatch,
+		},
+		{
+			name:  "larger than the artifact",
+			delta: instructions(want, "!"),
+			want:  ErrMismatch,
+		},
+		{
+			name:  "smaller than the artifact",
+			delta: instructions(want[:10]),
+			want:  ErrMismatch,
+		},
+		{
+			name:  "other content",
+			delta: instructions("the old release of the service, patched"),
+			want:  ErrMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest, err := rebuild(t, tt.delta, want)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Apply() error = %v, want %v", err, tt.want)
+			}
+			if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("rebuilt artifact kept after %v", tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+// Package history keeps the update history of the host: an append-only JSONL
+// file with one entry per check, detected version, update request, download,
+// activation and rollback, so that it can be told which versions were
+// installed when, at whose request and with what outcome.
+//
+// The entries form a hash chain: each one holds its sequence number, the hash
+// of the previous entry and its own hash, computed over the entry with the
+// hash left empty. Editing, inserting or removing an entry, or adding one
+// without a hash, breaks the chain, which is checked by Verify.
+//
+// Removing the last entries does not break the chain, so the hash of the last
+// entry, the head, must be noted away from the host, where whoever can edit
+// the history cannot change it, e.g. by the operator verifying it. Verify then
+// checks that the head noted is still in the chain.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+)
+
+// maxEntrySize is the longest entry read.
+const maxEntrySize = 1024 * 1024
+
+// DefaultPath is where the updater agent records the history and the server
+// and the CLI read it.
+const DefaultPath = "/home/sormazabal/src/SALTO2/update_history.jsonl"
+
+// Events of the history.
+const (
+	// EventCheck is an update check, with the latest version found.
+	EventCheck = "check"
+	// EventDetected is a new version offered to the host, or held back.
+	EventDetected = "detected"
+	// EventRequest is an update requested from the UI, the CLI or by the
+	// update policy.
+	EventRequest = "request"
+	// EventDownload is the download and verification of an artifact.
+	EventDownload = "download"
+	// EventActivate is the switch to a new version and the restart of the
+	// service, and EventRollback the same to an older version.
+	EventActivate = "activate"
+	EventRollback = "rollback"
+)
+
+// Sources of the checks and the update requests.
+const (
+	SourceSchedule = "schedule"
+	SourceRequest  = "request"
+	SourceUI       = "ui"
+	SourceCLI      = "cli"
+	SourcePolicy   = "policy"
+	SourceBundle   = "bundle"
+)
+
+// Outcomes of the entries.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeFailure  = "failure"
+	OutcomeHeldBack = "held_back"
+)
+
+// Entry is a line of the history.
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Service string    `json:"service,omitempty"`
+	// Version is the version the entry is about and From the version
+	// installed before it was activated.
+	Version string `json:"version,omitempty"`
+	From    string `json:"from,omitempty"`
+	// Source and User tell who triggered the entry.
+	Source string `json:"source,omitempty"`
+	User   string `json:"user,omitempty"`
+	// UpdateID is shared by the entries, and the logs, of an update attempt.
+	UpdateID string `json:"update_id,omitempty"`
+	Outcome  string `json:"outcome"`
+	// Detail adds how the outcome was reached, e.g. the artifact was fetched
+	// from a peer, and Error why it failed or was held back.
+	Detail     string `json:"detail,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	// Metadata holds the versions of the trusted TUF metadata when the entry
+	// was recorded.
+	Metadata *tufclient.Versions `json:"metadata,omitempty"`
+	// Seq is the position of the entry in the chain, from 1, Prev the hash
+	// of the previous entry and Hash the hash of this one. They are set by
+	// Append.
+	Seq  uint64 `json:"seq,omitempty"`
+	Prev string `json:"prev,omitempty"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// ErrTampered is returned by Verify when the chain is broken.
+var ErrTampered = errors.New("the update history has been tampered with")
+
+// sum returns the hash of e, computed with its Hash empty.
+func (e Entry) sum() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// Duration returns how long the entry took.
+func (e Entry) Duration() time.Duration {
+	return time.Duration(e.DurationMS) * time.Millisecond
+}
+
+// Outcome returns OutcomeSuccess when err is nil and OutcomeFailure otherwise.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// Since returns the milliseconds elapsed since start, for DurationMS.
+func Since(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
+
+// mu serializes the appends of the process and a lock on the file those of
+// the processes sharing it, the updater and the CLI, so that the entries are
+// chained in the order they are written.
+var mu sync.Mutex
+
+// Append adds e to the history stored in path, setting its time when unset,
+// and chains it to the last entry. It fails if the last entry cannot be read,
+// as e could not be chained to it.
+func Append(path string, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the history: %w", err)
+	}
+	defer f.Close()
+	// released when the file is closed
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock the history: %w", err)
+	}
+
+	last, err := lastEntry(f)
+	if err != nil {
+		return err
+	}
+	// entries recorded before the history was chained start a new chain, the
+	// history is then reported as tampered with by Verify
+	e.Seq, e.Prev = 1, ""
+	if last != nil && last.Hash != "" {
+		e.Seq, e.Prev = last.Seq+1, last.Hash
+	}
+	if e.Hash, err = e.sum(); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write the history: %w", err)
+	}
+	return f.Close()
+}
+
+// lastEntry returns the last entry of f, nil when empty.
+func lastEntry(f *os.File) (*Entry, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// the entries are much shorter than maxEntrySize, the last one is read
+	// from the end of the file
+	offset := max(info.Size()-maxEntrySize, 0)
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read the history: %w", err)
+	}
+
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	} else if offset > 0 {
+		return nil, errors.New("the last history entry is too long")
+	}
+	var e Entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, fmt.Errorf("invalid last history entry: %w", err)
+	}
+	return &e, nil
+}
+
+// Report describes a verified history.
+type Report struct {
+	// Entries is the number of entries.
+	Entries int
+	// Head is the hash of the last entry, to note away from the host and
+	// pass to the next Verify.
+	Head string
+}
+
+// Verify checks the hash chain of the history stored in path. The error wraps
+// ErrTampered and tells the line of the first entry edited, inserted, removed,
+// unchained or unreadable. When head, the hash of the last entry noted on a
+// previous verification, is not empty, the entry must still be in the chain,
+// otherwise the last entries were removed.
+func Verify(path, head string) (Report, error) {
+	var report Report
+	entries, err := Read(path)
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return report, fmt.Errorf("%w: %w", ErrTampered, err)
+	}
+	if err != nil {
+		return report, err
+	}
+
+	var prev *Entry
+	headFound := false
+	for i, e := range entries {
+		line := i + 1
+		if e.Hash == "" {
+			return report, fmt.Errorf("%w: entry at line %d is not chained", ErrTampered, line)
+		}
+		sum, err := e.sum()
+		if err != nil {
+			return report, err
+		}
+		switch {
+		case sum != e.Hash:
+			return report, fmt.Errorf("%w: entry at line %d has been edited", ErrTampered, line)
+		case prev == nil && (e.Seq != 1 || e.Prev != ""):
+			return report, fmt.Errorf("%w: entries before line %d are missing", ErrTampered, line)
+		case prev != nil && e.Seq != prev.Seq+1:
+			return report, fmt.Errorf("%w: entry at line %d is number %d, expected %d", ErrTampered, line, e.Seq, prev.Seq+1)
+		case prev != nil && e.Prev != prev.Hash:
+			return report, fmt.Errorf("%w: entry at line %d does not follow the previous one", ErrTampered, line)
+		}
+		prev = &entries[i]
+		headFound = headFound || e.Hash == head
+	}
+	if head != "" && !headFound {
+		return report, fmt.Errorf("%w: the entry %s noted before is missing", ErrTampered, head)
+	}
+
+	report.Entries = len(entries)
+	if prev != nil {
+		report.Head = prev.Hash
+	}
+	return report, nil
+}
+
+// Read returns the entries stored in path, oldest first. A missing file is
+// not an error, there is no history yet.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxEntrySize)
+	for n := 1; scanner.Scan(); n++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid history entry at line %d: %w", n, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Filter selects the entries of an event, all of them when empty.
+func Filter(entries []Entry, event string) []Entry {
+	if event == "" {
+		return entries
+	}
+	var selected []Entry
+	for _, e := range entries {
+		if e.Event == event {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
+
+// Recent returns the last n entries, newest first, all of them when n is not
+// positive.
+func Recent(entries []Entry, n int) []Entry {
+	if n <= 0 || n > len(entries) {
+		n = len(entries)
+	}
+	recent := make([]Entry, 0, n)
+	for i := len(entries) - 1; i >= len(entries)-n; i-- {
+		recent = append(recent, entries[i])
+	}
+	return recent
+}
@@ -0,0 +1,150 @@
+package history
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// record appends n entries to a new history and returns its path and lines.
+func record(t *testing.T, n int) (string, [][]byte) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "update_history.jsonl")
+	start := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	for i := range n {
+		e := Entry{Time: start.Add(time.Duration(i) * time.Minute), Event: EventCheck, Version: "v2025.03.01-sha.1a2b3c4", Outcome: OutcomeSuccess}
+		if err := Append(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path, bytes.SplitAfter(bytes.TrimRight(data, "\n"), []byte("\n"))
+}
+
+func write(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	data := bytes.Join(lines, nil)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path, _ := record(t, 4)
+	report, err := Verify(path, "")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Entries != 4 || report.Head != entries[3].Hash {
+		t.Errorf("report = %+v, want 4 entries and the hash of the last one", report)
+	}
+	if _, err := Verify(path, entries[1].Hash); err != nil {
+		t.Errorf("Verify() with an older head error = %v", err)
+	}
+
+	if report, err := Verify(filepath.Join(t.TempDir(), "missing.jsonl"), ""); err != nil || report.Entries != 0 {
+		t.Errorf("Verify() of no history = %+v, %v", report, err)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines [][]byte) [][]byte
+		// head is the index of the entry noted as the head, -1 for none
+		head int
+	}{
+		{
+			name: "edited entry",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1] = bytes.Replace(lines[1], []byte(`"success"`), []byte(`"failure"`), 1)
+				return lines
+			},
+			head: -1,
+		},
+		{
+			name: "removed middle entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return slices.Delete(lines, 1, 2)
+			},
+			head: -1,
+		},
+		{
+			name: "removed first entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[1:]
+			},
+			head: -1,
+		},
+		{
+			name: "swapped order",
+			tamper: func(lines [][]byte) [][]byte {
+				lines[1], lines[2] = lines[2], lines[1]
+				return lines
+			},
+			head: -1,
+		},
+		{
+			name: "inserted unchained prefix",
+			tamper: func(lines [][]byte) [][]byte {
+				return append([][]byte{[]byte(`{"time":"2025-02-28T08:00:00Z","event":"check","outcome":"success"}` + "\n")}, lines...)
+			},
+			head: -1,
+		},
+		{
+			name: "appended unchained entry",
+			tamper: func(lines [][]byte) [][]byte {
+				return append(lines, []byte(`{"time":"2025-03-02T08:00:00Z","event":"check","outcome":"success"}`+"\n"))
+			},
+			head: -1,
+		},
+		{
+			name: "corrupt last line",
+			tamper: func(lines [][]byte) [][]byte {
+				last := len(lines) - 1
+				lines[last] = lines[last][:len(lines[last])/2]
+				return lines
+			},
+			head: -1,
+		},
+		{
+			name: "removed last entries",
+			tamper: func(lines [][]byte) [][]byte {
+				return lines[:2]
+			},
+			head: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, lines := record(t, 4)
+			var head string
+			if tt.head >= 0 {
+				entries, err := Read(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				head = entries[tt.head].Hash
+			}
+			write(t, path, tt.tamper(lines))
+			if _, err := Verify(path, head); !errors.Is(err, ErrTampered) {
+				t.Errorf("Verify() error = %v, want ErrTampered", err)
+			}
+		})
+	}
+}
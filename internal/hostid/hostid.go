@@ -0,0 +1,40 @@
+// Package hostid provides a stable identity for the host, generated on first
+// use and persisted so that it survives restarts and updates.
+package hostid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Load returns the host ID stored in path, generating and storing a new one if
+// the file does not exist.
+func Load(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read host ID: %w", err)
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate host ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(id+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to store host ID: %w", err)
+	}
+	return id, nil
+}
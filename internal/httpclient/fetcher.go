@@ -0,0 +1,61 @@
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/fetcher"
+)
+
+// Fetcher implements the go-tuf fetcher.Fetcher interface on top of a
+// configured HTTP client, so TUF metadata and targets go through the same
+// proxy, CA bundle and timeouts as the artifact downloads.
+type Fetcher struct {
+	client *http.Client
+}
+
+var _ fetcher.Fetcher = (*Fetcher)(nil)
+
+// NewFetcher returns a go-tuf fetcher using client.
+func NewFetcher(client *http.Client) *Fetcher {
+	return &Fetcher{client: client}
+}
+
+// DownloadFile downloads urlPath, failing if it is larger than maxLength or if
+// it takes longer than timeout.
+func (f *Fetcher) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, &metadata.ErrDownloadHTTP{StatusCode: res.StatusCode, URL: urlPath}
+	}
+	if res.ContentLength > maxLength {
+		return nil, &metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("download failed for %s, length %d is larger than expected %d", urlPath, res.ContentLength, maxLength)}
+	}
+
+	// read one byte more than allowed to detect a body larger than announced
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxLength {
+		return nil, &metadata.ErrDownloadLengthMismatch{Msg: fmt.Sprintf("download failed for %s, length %d is larger than expected %d", urlPath, len(data), maxLength)}
+	}
+	return data, nil
+}
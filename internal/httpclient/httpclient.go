@@ -0,0 +1,157 @@
+// Package httpclient builds the HTTP client used for every request done by the
+// updater: TUF metadata, TUF targets and artifact downloads.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/ratelimit"
+)
+
+const (
+	defaultConnectTimeout = 10 * time.Second
+	defaultReadTimeout    = 30 * time.Second
+)
+
+// Config holds the transport settings of the client.
+type Config struct {
+	// ProxyURL is the proxy used for every request. When empty, the
+	// HTTPS_PROXY and NO_PROXY environment variables are honoured.
+	ProxyURL string
+	// CAFile is a PEM bundle with certificates trusted on top of the system
+	// ones.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key presented to
+	// servers requesting mutual TLS.
+	CertFile string
+	KeyFile  string
+	// ConnectTimeout bounds the TCP connection and the TLS handshake.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds the time waiting for the response headers.
+	ReadTimeout time.Duration
+	// UserAgent is sent with every request.
+	UserAgent string
+	// Limiter throttles every response body, metadata and artifacts alike.
+	Limiter *ratelimit.Limiter
+}
+
+// UserAgent returns the User-Agent of the updater running version.
+func UserAgent(version string) string {
+	if version == "" {
+		version = "unknown"
+	}
+	return fmt.Sprintf("general-service-updater/%s (%s; %s)", version, runtime.GOOS, runtime.GOARCH)
+}
+
+// New returns an HTTP client configured according to cfg.
+func New(cfg Config) (*http.Client, error) {
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = defaultConnectTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = defaultReadTimeout
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.ConnectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   cfg.ConnectTimeout,
+		ResponseHeaderTimeout: cfg.ReadTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+		ForceAttemptHTTP2:     true,
+	}
+
+	return &http.Client{
+		Transport: &userAgentTransport{
+			next:      &limitTransport{next: transport, limiter: cfg.Limiter},
+			userAgent: cfg.UserAgent,
+		},
+	}, nil
+}
+
+// userAgentTransport sets the User-Agent header on every request.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.userAgent == "" || req.Header.Get("User-Agent") != "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+	return t.next.RoundTrip(req)
+}
+
+// limitTransport throttles the response bodies with a rate limiter.
+type limitTransport struct {
+	next    http.RoundTripper
+	limiter *ratelimit.Limiter
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil || t.limiter == nil {
+		return res, err
+	}
+	res.Body = &limitedBody{
+		Reader: t.limiter.Reader(req.Context(), res.Body),
+		Closer: res.Body,
+	}
+	return res, nil
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
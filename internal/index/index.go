@@ -0,0 +1,125 @@
+// Package index describes the artifacts listed in the index of a service.
+//
+// Schema 1 indexes list a single artifact per service through the path, bytes
+// and hashes fields. Schema 2 indexes list one artifact per platform:
+//
+//	"general-service": {
+//	  "schema": 2,
+//	  "version": "v2025.03.01-sha.1a2b3c4",
+//	  "release-date": "2025-03-01T08:00:00Z",
+//	  "artifacts": [
+//	    {"os": "linux", "arch": "amd64", "path": "...", "bytes": "...", "hashes": {"sha256": "..."}},
+//	    {"os": "linux", "arch": "arm64", "variant": "musl", "path": "...", "bytes": "...", "hashes": {"sha256": "..."}}
+//	  ]
+//	}
+package index
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// SchemaVersion is the newest index schema understood by this updater.
+const SchemaVersion = 2
+
+var (
+	// ErrUnsupportedSchema is returned for indexes newer than SchemaVersion.
+	ErrUnsupportedSchema = errors.New("unsupported index schema")
+	// ErrNoArtifact is returned when no artifact is built for the platform.
+	ErrNoArtifact = errors.New("no artifact for this platform")
+)
+
+// Hashes holds the digests of an artifact.
+type Hashes struct {
+	Sha256 string `json:"sha256"`
+}
+
+// Artifact is a build of the service for a platform.
+type Artifact struct {
+	OS      string `json:"os,omitempty"`
+	Arch    string `json:"arch,omitempty"`
+	Variant string `json:"variant,omitempty"`
+	Path    string `json:"path"`
+	Bytes   string `json:"bytes"`
+	Hashes  Hashes `json:"hashes"`
+	// Delta optionally describes a smaller artifact to update from a
+	// previous release.
+	Delta *Delta `json:"delta,omitempty"`
+}
+
+// Delta rebuilds the artifact from the artifact of the release From, see
+// package delta. The rebuilt artifact must match the bytes and the hashes of
+// the artifact:
+//
+//	"delta": {
+//	  "from": "v2025.03.01-sha.1a2b3c4",
+//	  "path": "...", "bytes": "...", "hashes": {"sha256": "..."}
+//	}
+type Delta struct {
+	From   string `json:"from"`
+	Path   string `json:"path"`
+	Bytes  string `json:"bytes"`
+	Hashes Hashes `json:"hashes"`
+}
+
+// Platform returns the os/arch[/variant] of the artifact.
+func (a Artifact) Platform() string {
+	return platform(a.OS, a.Arch, a.Variant)
+}
+
+// Length returns the size in bytes of the artifact, bounding its download.
+func (a Artifact) Length() (int64, error) {
+	return parseLength("artifact", a.Bytes)
+}
+
+// Length returns the size in bytes of the delta, bounding its download.
+func (d Delta) Length() (int64, error) {
+	return parseLength("delta", d.Bytes)
+}
+
+func parseLength(what, bytes string) (int64, error) {
+	n, err := strconv.ParseInt(bytes, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s size %q", what, bytes)
+	}
+	return n, nil
+}
+
+// CheckSchema returns an error wrapping ErrUnsupportedSchema if schema is
+// newer than SchemaVersion. Indexes without a schema field are schema 1.
+func CheckSchema(schema int) error {
+	if schema > SchemaVersion {
+		return fmt.Errorf("%w %d, this updater supports up to schema %d and must be updated first", ErrUnsupportedSchema, schema, SchemaVersion)
+	}
+	return nil
+}
+
+// Select returns the artifact built for goos and goarch. The artifact of the
+// requested variant is preferred, falling back to the one without a variant.
+func Select(artifacts []Artifact, goos, goarch, variant string) (Artifact, error) {
+	var fallback *Artifact
+	for i, a := range artifacts {
+		if a.OS != goos || a.Arch != goarch {
+			continue
+		}
+		if a.Variant == variant {
+			return a, nil
+		}
+		if a.Variant == "" {
+			fallback = &artifacts[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, nil
+	}
+	return Artifact{}, fmt.Errorf("%w %s", ErrNoArtifact, platform(goos, goarch, variant))
+}
+
+func platform(goos, goarch, variant string) string {
+	p := goos + "/" + goarch
+	if variant != "" {
+		p += "/" + variant
+	}
+	return p
+}
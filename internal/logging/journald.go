@@ -0,0 +1,126 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+// journalHandler is a slog.Handler sending the records to the systemd journal
+// natively: the level is the priority of the entry and every attribute is a
+// field of its own, e.g. phase=download is PHASE=download, so that the
+// entries can be filtered with journalctl PHASE=download.
+type journalHandler struct {
+	opts   slog.HandlerOptions
+	fields map[string]string
+	prefix string
+}
+
+func newJournalHandler(opts *slog.HandlerOptions) *journalHandler {
+	return &journalHandler{opts: *opts, fields: map[string]string{}}
+}
+
+// runningUnderJournald reports whether the standard output is connected to
+// the journal, as it is for the systemd units by default.
+func runningUnderJournald() bool {
+	ok, err := journal.StdoutIsJournalStream()
+	return err == nil && ok && journal.Enabled()
+}
+
+func (h *journalHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.opts.Level.Level()
+}
+
+func (h *journalHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := make(map[string]string, len(h.fields)+r.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addField(fields, h.prefix, a)
+		return true
+	})
+	return journal.Send(r.Message, priority(r.Level), fields)
+}
+
+func (h *journalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.fields = make(map[string]string, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		h2.fields[k] = v
+	}
+	for _, a := range attrs {
+		addField(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *journalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "_"
+	return &h2
+}
+
+// addField adds a to fields, flattening the groups into prefixed names.
+func addField(fields map[string]string, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range a.Value.Group() {
+			addField(fields, prefix, ga)
+		}
+		return
+	}
+	if name := fieldName(prefix + a.Key); name != "" {
+		fields[name] = fmt.Sprint(a.Value.Any())
+	}
+}
+
+// fieldName turns an attribute key into a journal field name, made of
+// uppercase letters, digits and underscores and not starting with an
+// underscore. Keys with nothing usable are dropped.
+func fieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" {
+		return ""
+	}
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "F_" + name
+	}
+	return name
+}
+
+// priority maps the slog levels to the journal priorities.
+func priority(l slog.Level) journal.Priority {
+	switch {
+	case l >= slog.LevelError:
+		return journal.PriErr
+	case l >= slog.LevelWarn:
+		return journal.PriWarning
+	case l >= slog.LevelInfo:
+		return journal.PriInfo
+	default:
+		return journal.PriDebug
+	}
+}
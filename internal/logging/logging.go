@@ -0,0 +1,161 @@
+// Package logging builds the structured logger shared by the updater, the
+// server and the CLI on top of log/slog.
+//
+// Records are written as text or JSON to stdout and, optionally, to a log
+// file rotated by size and age, or sent to the systemd journal. The fields
+// below identify what a record is about, so that the logs of an update can be
+// followed from the check to the install:
+//
+//	logger.With(logging.Service, "general-service", logging.Phase, logging.PhaseDownload, logging.UpdateID, id)
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+// Fields of the records.
+const (
+	Service  = "service"
+	Version  = "version"
+	Phase    = "phase"
+	UpdateID = "update_id"
+)
+
+// Phases of the updater, the server and the CLI.
+const (
+	PhaseStartup  = "startup"
+	PhaseCheck    = "check"
+	PhasePrefetch = "prefetch"
+	PhaseDownload = "download"
+	PhaseInstall  = "install"
+	PhaseTUF      = "tuf"
+	PhaseServe    = "serve"
+	PhaseMirror   = "mirror"
+	PhaseBundle   = "bundle"
+	PhaseNotify   = "notify"
+)
+
+// Formats of the records.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Outputs of the records: stdout and the log file, if any, the systemd
+// journal, or the journal when running under systemd and stdout otherwise.
+const (
+	OutputStdout   = "stdout"
+	OutputJournald = "journald"
+	OutputAuto     = "auto"
+)
+
+// level is shared by every logger, so that it can be changed once they are
+// created, e.g. by the debug flag of the server.
+var level slog.LevelVar
+
+// Config holds the logging configuration.
+type Config struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level string
+	// Format is text or json.
+	Format string
+	// Path is the log file the records are written to besides stdout.
+	Path string
+	// Rotation configures the rotation of the log file.
+	Rotation Rotation
+	// Output is where the records are written, stdout when empty. The log
+	// file is not written when they are sent to the journal.
+	Output string
+}
+
+// New creates the logger described by cfg. The returned closer closes the log
+// file, if any.
+func New(cfg Config) (*slog.Logger, io.Closer, error) {
+	l, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+	level.Set(l)
+	opts := &slog.HandlerOptions{Level: &level}
+
+	switch strings.ToLower(cfg.Output) {
+	case "", OutputStdout:
+	case OutputJournald:
+		return slog.New(newJournalHandler(opts)), nopCloser{}, nil
+	case OutputAuto:
+		if runningUnderJournald() {
+			return slog.New(newJournalHandler(opts)), nopCloser{}, nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid log output %q, expected %s, %s or %s", cfg.Output, OutputStdout, OutputJournald, OutputAuto)
+	}
+
+	var (
+		w      io.Writer = os.Stdout
+		closer io.Closer = nopCloser{}
+	)
+	if cfg.Path != "" {
+		f, err := openRotatingFile(cfg.Path, cfg.Rotation)
+		if err != nil {
+			return nil, nil, err
+		}
+		w = io.MultiWriter(os.Stdout, f)
+		closer = f
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		closer.Close()
+		return nil, nil, fmt.Errorf("invalid log format %q, expected %s or %s", cfg.Format, FormatText, FormatJSON)
+	}
+	return slog.New(h), closer, nil
+}
+
+// ParseLevel parses a level name, info when empty.
+func ParseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", s)
+	}
+	return l, nil
+}
+
+// SetLevel changes the minimum level of every logger created by New.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// TUF returns a logr logger for go-tuf writing through logger. go-tuf logs
+// its progress with V(1) and above, which is the debug level.
+func TUF(logger *slog.Logger) logr.Logger {
+	return logr.FromSlogHandler(logger.With(Phase, PhaseTUF).Handler())
+}
+
+// NewUpdateID returns a random ID for the records of an update attempt.
+func NewUpdateID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
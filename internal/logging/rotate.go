@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/ratelimit"
+)
+
+// backupTimeFormat names the rotated files after the rotation time, so that
+// they sort in the order they were rotated.
+const backupTimeFormat = "20060102T150405.000"
+
+// Rotation configures the rotation of the log file. The zero value never
+// rotates it.
+type Rotation struct {
+	// MaxSize is the size, e.g. 10M, past which the file is rotated, see
+	// ratelimit.ParseSize.
+	MaxSize string
+	// MaxAge is how long the records are appended to the same file.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept, all of them when 0.
+	MaxBackups int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// rotatingFile is a log file rotated as configured. The rotated files are
+// renamed to <path>.<time>, followed by .gz when compressed.
+type rotatingFile struct {
+	path    string
+	cfg     Rotation
+	maxSize uint64
+
+	mu     sync.Mutex
+	f      *os.File
+	size   uint64
+	opened time.Time
+}
+
+// openRotatingFile opens path for appending, rotating it first when it was
+// last written longer than the maximum age ago.
+func openRotatingFile(path string, cfg Rotation) (*rotatingFile, error) {
+	maxSize, err := ratelimit.ParseSize(cfg.MaxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log file size: %w", err)
+	}
+	r := &rotatingFile{path: path, cfg: cfg, maxSize: maxSize}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if info, err := r.f.Stat(); err == nil && r.size > 0 && cfg.MaxAge > 0 && time.Since(info.ModTime()) >= cfg.MaxAge {
+		if err := r.rotate(); err != nil {
+			r.f.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	r.f = f
+	r.size = uint64(info.Size())
+	r.opened = time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p does not fit or the
+// file is too old. Records are never split across files.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	tooBig := r.maxSize > 0 && r.size+uint64(len(p)) > r.maxSize
+	tooOld := r.cfg.MaxAge > 0 && time.Since(r.opened) >= r.cfg.MaxAge
+	if r.size > 0 && (tooBig || tooOld) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += uint64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// rotate moves the current file aside, compressing it if configured, removes
+// the backups beyond the retention count and opens a new file.
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	r.f = nil
+
+	backup := r.path + "." + time.Now().Format(backupTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	// the records keep going to the new file even if the backup cannot be
+	// compressed or the old ones removed
+	if r.cfg.Compress {
+		if err := compress(backup); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compress %s: %v\n", backup, err)
+		}
+	}
+	if err := r.prune(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to remove old log files: %v\n", err)
+	}
+	return nil
+}
+
+// prune removes the oldest backups beyond MaxBackups.
+func (r *rotatingFile) prune() error {
+	if r.cfg.MaxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return err
+	}
+	// uncompressed backups left by a failed compression are counted too
+	kept := backups[:0]
+	for _, b := range backups {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(b, r.path+"."), ".gz")
+		if _, err := time.Parse(backupTimeFormat, stamp); err == nil {
+			kept = append(kept, b)
+		}
+	}
+	sort.Strings(kept)
+	for len(kept) > r.cfg.MaxBackups {
+		if err := os.Remove(kept[0]); err != nil {
+			return err
+		}
+		kept = kept[1:]
+	}
+	return nil
+}
+
+// compress gzips path into path.gz and removes path.
+func compress(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(path)
+}
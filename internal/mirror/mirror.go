@@ -0,0 +1,493 @@
+// Package mirror runs a read-only cache of the TUF repository for a site.
+//
+// The metadata and the service indexes are refreshed with the same go-tuf
+// client used by the agents and only what was verified is published, so the
+// agents of the site can use the mirror as their metadata and targets URLs
+// while keeping their own trusted root. The artifacts referenced by the
+// indexes are private: they are served by SHA-256 to the authenticated hosts of
+// the local subnets, as the peers of package p2p do, so the agents list the
+// mirror as a static peer sharing the token of the site.
+//
+// The mirror directory is laid out as:
+//
+//	trusted/    metadata trusted after the last successful sync
+//	metadata/   metadata served at /metadata/
+//	targets/    targets served at /targets/
+//	artifacts/  artifacts served at /artifacts/<sha256>
+package mirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/httpclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/index"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/p2p"
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/fetcher"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	trustedDir   = "trusted"
+	metadataDir  = "metadata"
+	targetsDir   = "targets"
+	artifactsDir = "artifacts"
+
+	defaultInterval     = 5 * time.Minute
+	defaultMaxArtifacts = 50
+)
+
+// Config holds the mirror configuration.
+type Config struct {
+	// MetadataURL and TargetsURL locate the origin repository.
+	MetadataURL string
+	TargetsURL  string
+	// Root is the root.json to trust. When empty, the first root of the
+	// origin is trusted on first use, as the agents do.
+	Root string
+	// Dir holds the trusted metadata and the mirrored content.
+	Dir string
+	// Addr is where the mirrored content is served, e.g. ":8080".
+	Addr string
+	// Token authenticates the agents fetching the artifacts, see package
+	// p2p. It is required to Run the mirror.
+	Token []byte
+	// Services and Channels select the indexes that are mirrored.
+	Services []string
+	Channels []string
+	// Interval is the time between two syncs with the origin.
+	Interval time.Duration
+	// MaxArtifacts is the number of artifacts kept.
+	MaxArtifacts int
+	// HTTPClient is used to reach the origin.
+	HTTPClient *http.Client
+	// ServiceAccountKey is the Google service account key used to download
+	// the artifacts, they are downloaded anonymously when empty.
+	ServiceAccountKey string
+}
+
+// Mirror keeps a verified copy of the origin repository and serves it.
+type Mirror struct {
+	cfg       Config
+	logger    *slog.Logger
+	artifacts *p2p.Node
+	tokens    oauth2.TokenSource
+}
+
+// New creates a mirror, trusting the root already in cfg.Dir or, the first
+// time, cfg.Root or the first root of the origin.
+func New(cfg Config, logger *slog.Logger) (*Mirror, error) {
+	if cfg.MetadataURL == "" || cfg.TargetsURL == "" {
+		return nil, errors.New("invalid config: metadata or targets URL missing")
+	}
+	if cfg.Dir == "" {
+		return nil, errors.New("invalid config: mirror directory missing")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultInterval
+	}
+	if cfg.MaxArtifacts <= 0 {
+		cfg.MaxArtifacts = defaultMaxArtifacts
+	}
+	if len(cfg.Channels) == 0 {
+		cfg.Channels = []string{channel.Stable}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	for _, dir := range []string{trustedDir, metadataDir, targetsDir} {
+		if err := os.MkdirAll(filepath.Join(cfg.Dir, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &Mirror{cfg: cfg, logger: logger.With(logging.Phase, logging.PhaseMirror)}
+	var err error
+	m.artifacts, err = p2p.New(p2p.Config{
+		Token:     cfg.Token,
+		CacheDir:  filepath.Join(cfg.Dir, artifactsDir),
+		MaxCached: cfg.MaxArtifacts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.ServiceAccountKey != "" {
+		key, err := os.ReadFile(cfg.ServiceAccountKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account key: %w", err)
+		}
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, cfg.HTTPClient)
+		creds, err := google.CredentialsFromJSON(ctx, key, "https://www.googleapis.com/auth/cloud-platform")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load service account credentials: %w", err)
+		}
+		m.tokens = creds.TokenSource
+	}
+
+	if err := m.initRoot(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Run serves the mirrored content and syncs it with the origin every
+// Interval until ctx is done. A failed sync keeps serving the content of the
+// last successful one. The mirror does not start without a token.
+func (m *Mirror) Run(ctx context.Context) error {
+	if len(m.cfg.Token) == 0 {
+		return p2p.ErrNoToken
+	}
+	srv := &http.Server{Addr: m.cfg.Addr, Handler: m.handler(), ReadHeaderTimeout: 10 * time.Second}
+
+	ln, err := net.Listen("tcp", m.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+	m.logger.Info("Mirror started", "addr", m.cfg.Addr, "dir", m.cfg.Dir)
+
+	for {
+		if err := m.Sync(ctx); err != nil {
+			m.logger.Error("Mirror sync failed", "error", err, "retryIn", m.cfg.Interval)
+		} else {
+			m.logger.Info("Mirror synced", "retryIn", m.cfg.Interval)
+		}
+
+		select {
+		case <-time.After(m.cfg.Interval):
+		case err := <-errc:
+			return err
+		case <-ctx.Done():
+			return srv.Close()
+		}
+	}
+}
+
+// handler serves the metadata and the targets to anyone, as they are verified
+// by the agents anyway, and the artifacts to the authenticated peers only.
+func (m *Mirror) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metadata/", http.StripPrefix("/metadata/", http.FileServer(http.Dir(m.path(metadataDir)))))
+	mux.Handle("GET /targets/", http.StripPrefix("/targets/", http.FileServer(http.Dir(m.path(targetsDir)))))
+	mux.Handle("GET /artifacts/", m.artifacts.Handler())
+	return mux
+}
+
+// Sync refreshes the metadata and the indexes from the origin, downloads the
+// artifacts they reference and publishes all of it. Nothing is published
+// unless every step succeeds.
+func (m *Mirror) Sync(ctx context.Context) error {
+	work, err := os.MkdirTemp(m.cfg.Dir, ".sync-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	// go-tuf starts from the metadata trusted on the last successful sync,
+	// so every file it downloads now is new and has to be published
+	workMetadata := filepath.Join(work, metadataDir)
+	if err := copyFiles(m.path(trustedDir), workMetadata); err != nil {
+		return err
+	}
+
+	rec := &recorder{fetcher: httpclient.NewFetcher(m.cfg.HTTPClient), files: make(map[string][]byte)}
+	client, err := tufclient.New(tufclient.Config{
+		MetadataURL: m.cfg.MetadataURL,
+		TargetsURL:  m.cfg.TargetsURL,
+		MetadataDir: workMetadata,
+		TargetsDir:  filepath.Join(work, targetsDir),
+		Fetcher:     rec,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Refresh(); err != nil {
+		return err
+	}
+
+	var artifacts []index.Artifact
+	for _, service := range m.cfg.Services {
+		for _, ch := range m.cfg.Channels {
+			targetPath := channel.IndexPath(service, ch)
+			tb, _, err := client.DownloadTarget(targetPath, filepath.Join(work, targetsDir, filepath.FromSlash(targetPath)))
+			if err != nil {
+				return err
+			}
+			referenced, err := referencedArtifacts(tb, service)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", targetPath, err)
+			}
+			artifacts = append(artifacts, referenced...)
+		}
+	}
+
+	for _, a := range artifacts {
+		if err := m.fetchArtifact(ctx, a); err != nil {
+			return fmt.Errorf("failed to mirror artifact %s: %w", a.Path, err)
+		}
+	}
+
+	if err := m.publish(rec.files); err != nil {
+		return err
+	}
+	return replaceDir(workMetadata, m.path(trustedDir))
+}
+
+// initRoot makes sure there is a trusted root and that it is published, so
+// that agents starting from it can fetch the newer ones.
+func (m *Mirror) initRoot() error {
+	trusted := filepath.Join(m.path(trustedDir), "root.json")
+	data, err := os.ReadFile(trusted)
+	switch {
+	case err == nil:
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	case m.cfg.Root != "":
+		if data, err = os.ReadFile(m.cfg.Root); err != nil {
+			return fmt.Errorf("failed to read trusted root: %w", err)
+		}
+	default:
+		rootURL, err := url.JoinPath(m.cfg.MetadataURL, "1.root.json")
+		if err != nil {
+			return err
+		}
+		if data, err = httpclient.NewFetcher(m.cfg.HTTPClient).DownloadFile(rootURL, 512000, time.Minute); err != nil {
+			return fmt.Errorf("failed to download 1.root.json: %w", err)
+		}
+	}
+
+	root, err := metadata.Root().FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("invalid trusted root: %w", err)
+	}
+	if err := writeFile(trusted, data); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(m.path(metadataDir), fmt.Sprintf("%d.root.json", root.Signed.Version)), data)
+}
+
+// fetchArtifact downloads the artifact from the origin unless it is already
+// mirrored. It is only kept if it matches the size and the hash of the
+// verified index, and no more than its size is downloaded.
+func (m *Mirror) fetchArtifact(ctx context.Context, a index.Artifact) error {
+	if m.artifacts.Has(a.Hashes.Sha256) {
+		return nil
+	}
+	length, err := a.Length()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.Path, nil)
+	if err != nil {
+		return err
+	}
+	if m.tokens != nil {
+		token, err := m.tokens.Token()
+		if err != nil {
+			return fmt.Errorf("failed to retrieve token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+
+	res, err := m.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return &httpclient.StatusError{URL: a.Path, StatusCode: res.StatusCode}
+	}
+
+	tmp, err := os.CreateTemp(m.cfg.Dir, ".artifact-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(res.Body, length+1))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if n > length {
+		return fmt.Errorf("size mismatch: more than the %d bytes expected", length)
+	}
+	if n < length {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d", n, length)
+	}
+	return m.artifacts.Store(tmp.Name(), a.Hashes.Sha256)
+}
+
+// publish writes the files downloaded by go-tuf where they are served.
+// timestamp.json goes last, so that the agents never see a timestamp before
+// the metadata and targets it refers to.
+func (m *Mirror) publish(files map[string][]byte) error {
+	var timestamp string
+	for u, data := range files {
+		dest, err := m.localPath(u)
+		if err != nil {
+			return err
+		}
+		if filepath.Base(dest) == metadata.TIMESTAMP+".json" {
+			timestamp = u
+			continue
+		}
+		if err := writeFile(dest, data); err != nil {
+			return err
+		}
+	}
+	if timestamp == "" {
+		return nil
+	}
+	dest, _ := m.localPath(timestamp)
+	return writeFile(dest, files[timestamp])
+}
+
+// localPath returns where the file downloaded from u is published.
+func (m *Mirror) localPath(u string) (string, error) {
+	for _, base := range []struct{ url, dir string }{
+		{m.cfg.MetadataURL, metadataDir},
+		{m.cfg.TargetsURL, targetsDir},
+	} {
+		rel, ok := strings.CutPrefix(u, strings.TrimSuffix(base.url, "/")+"/")
+		if !ok {
+			continue
+		}
+		rel, err := url.PathUnescape(rel)
+		if err != nil || !filepath.IsLocal(rel) {
+			break
+		}
+		return filepath.Join(m.path(base.dir), filepath.FromSlash(rel)), nil
+	}
+	return "", fmt.Errorf("unexpected download URL %s", u)
+}
+
+func (m *Mirror) path(dir string) string {
+	return filepath.Join(m.cfg.Dir, dir)
+}
+
+// referencedArtifacts returns every artifact listed for service in the index.
+func referencedArtifacts(data []byte, service string) ([]index.Artifact, error) {
+	var entries map[string]struct {
+		Path      string           `json:"path"`
+		Bytes     string           `json:"bytes"`
+		Hashes    index.Hashes     `json:"hashes"`
+		Artifacts []index.Artifact `json:"artifacts"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	entry, ok := entries[service]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found", service)
+	}
+	if len(entry.Artifacts) > 0 {
+		return entry.Artifacts, nil
+	}
+	return []index.Artifact{{Path: entry.Path, Bytes: entry.Bytes, Hashes: entry.Hashes}}, nil
+}
+
+// recorder is a go-tuf fetcher keeping a copy of every file downloaded.
+type recorder struct {
+	fetcher fetcher.Fetcher
+
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (r *recorder) DownloadFile(urlPath string, maxLength int64, timeout time.Duration) ([]byte, error) {
+	data, err := r.fetcher.DownloadFile(urlPath, maxLength, timeout)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[urlPath] = data
+	return data, nil
+}
+
+// writeFile atomically replaces path with data.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// copyFiles copies the regular files of src into dst.
+func copyFiles(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(src, e.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dst, e.Name()), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceDir moves src in place of dst.
+func replaceDir(src, dst string) error {
+	old := dst + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		os.Rename(old, dst)
+		return err
+	}
+	return os.RemoveAll(old)
+}
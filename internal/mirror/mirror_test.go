@@ -0,0 +1,122 @@
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/p2p"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+var token = []byte("site token")
+
+func newMirror(t *testing.T, token []byte) *Mirror {
+	t.Helper()
+	dir := t.TempDir()
+	root, err := metadata.Root(time.Now().Add(time.Hour)).ToBytes(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootPath := filepath.Join(dir, "root.json")
+	if err := os.WriteFile(rootPath, root, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(Config{
+		MetadataURL: "http://origin.invalid/metadata",
+		TargetsURL:  "http://origin.invalid/targets",
+		Root:        rootPath,
+		Dir:         filepath.Join(dir, "mirror"),
+		Addr:        "127.0.0.1:0",
+		Token:       token,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRunWithoutToken(t *testing.T) {
+	m := newMirror(t, nil)
+	if err := m.Run(context.Background()); !errors.Is(err, p2p.ErrNoToken) {
+		t.Errorf("Run() error = %v, want ErrNoToken", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := newMirror(t, token)
+	const artifact = "the artifact of the release"
+	path := filepath.Join(t.TempDir(), "artifact.zip")
+	if err := os.WriteFile(path, []byte(artifact), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256([]byte(artifact))
+	sum := hex.EncodeToString(h[:])
+	if err := m.artifacts.Store(path, sum); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(m.handler())
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		want          int
+	}{
+		{
+			name: "metadata",
+			path: "/metadata/1.root.json",
+			want: http.StatusOK,
+		},
+		{
+			name: "unauthenticated artifact",
+			path: "/artifacts/" + sum,
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:          "bearer token",
+			path:          "/artifacts/" + sum,
+			authorization: "Bearer " + string(token),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "authenticated artifact",
+			path:          "/artifacts/" + sum,
+			authorization: p2p.Authorization(token, http.MethodGet, host, "/artifacts/"+sum, time.Now()),
+			want:          http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+// Package notify tells the operators about the updates of a host: a webhook
+// is called when an update is available, applied, failed or rolled back.
+//
+// The events are queued in an outbox directory, one file per event and
+// webhook, before being delivered, so that they survive restarts and are
+// retried with backoff while the webhook is unreachable. They are delivered in
+// order to each webhook, at least once: the receivers tell the retries apart by
+// the delivery ID. The outbox is locked while delivering, so the agent and the
+// CLI can share it without delivering the same event twice.
+//
+// Every delivery is a POST of the event as JSON. When a secret is configured,
+// it is signed with HMAC-SHA256 over "<timestamp>.<body>":
+//
+//	X-General-Service-Event: applied
+//	X-General-Service-Delivery: <event ID>
+//	X-General-Service-Timestamp: <unix seconds>
+//	X-General-Service-Signature: sha256=<hex HMAC>
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/httpclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/retry"
+)
+
+// Events notified.
+const (
+	EventUpdateAvailable = "update-available"
+	EventApplied         = "applied"
+	EventFailed          = "failed"
+	EventRolledBack      = "rolled-back"
+)
+
+// Headers of the deliveries.
+const (
+	HeaderEvent     = "X-General-Service-Event"
+	HeaderDelivery  = "X-General-Service-Delivery"
+	HeaderTimestamp = "X-General-Service-Timestamp"
+	HeaderSignature = "X-General-Service-Signature"
+)
+
+// pollInterval is how often the outbox is looked at for deliveries due.
+const pollInterval = 5 * time.Second
+
+// DefaultTimeout bounds every delivery when no client is configured.
+const DefaultTimeout = 30 * time.Second
+
+// DefaultRetry retries every delivery for about two days.
+var DefaultRetry = retry.Policy{
+	InitialInterval: 10 * time.Second,
+	MaxInterval:     time.Hour,
+	Jitter:          0.5,
+	MaxAttempts:     50,
+}
+
+// Event is an update event of a service.
+type Event struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Host    string    `json:"host,omitempty"`
+	Service string    `json:"service,omitempty"`
+	// Version is the version the event is about and From the version
+	// installed before it, for the applied and rolled back updates.
+	Version  string `json:"version,omitempty"`
+	From     string `json:"from,omitempty"`
+	UpdateID string `json:"update_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Config holds the notification settings.
+type Config struct {
+	// URLs are the webhooks called on every event. Nothing is notified when
+	// empty.
+	URLs []string
+	// Secret signs the deliveries, they are not signed when empty.
+	Secret []byte
+	// Outbox is the directory where the events wait to be delivered. It
+	// should be absolute, as the notifier may outlive the working directory.
+	Outbox string
+	// Retry is the retry policy of every delivery, DefaultRetry when zero.
+	Retry retry.Policy
+	// Client sends the deliveries, a client timing out after DefaultTimeout
+	// when nil. It should not be the client of the downloads: the deliveries
+	// must not wait for their rate limit.
+	Client *http.Client
+}
+
+// Notifier queues the events in the outbox and delivers them.
+type Notifier struct {
+	cfg  Config
+	wake chan struct{}
+}
+
+// delivery is an event waiting in the outbox to be delivered to a webhook.
+type delivery struct {
+	URL         string    `json:"url"`
+	Event       Event     `json:"event"`
+	Attempts    uint64    `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// New creates a notifier. Nothing is delivered until Run is called.
+func New(cfg Config) (*Notifier, error) {
+	if cfg.Retry == (retry.Policy{}) {
+		cfg.Retry = DefaultRetry
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: DefaultTimeout}
+	}
+	if err := os.MkdirAll(cfg.Outbox, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the outbox: %w", err)
+	}
+	return &Notifier{cfg: cfg, wake: make(chan struct{}, 1)}, nil
+}
+
+// Notify queues e for every webhook, setting its ID and time when unset.
+func (n *Notifier) Notify(e Event) error {
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+
+	for i, url := range n.cfg.URLs {
+		// the names sort in the order the events are queued
+		name := fmt.Sprintf("%s-%s-%d.json", e.Time.Format("20060102T150405.000000000"), e.ID, i)
+		if err := n.save(name, delivery{URL: url, Event: e, NextAttempt: e.Time}); err != nil {
+			return fmt.Errorf("failed to queue the %s event: %w", e.Type, err)
+		}
+	}
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers the events of the outbox, including the ones left by a previous
+// run, until ctx is done.
+func (n *Notifier) Run(ctx context.Context, logger *slog.Logger) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if err := n.deliverDue(ctx, logger); err != nil {
+			logger.Error("Failed to read the outbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// deliverDue attempts the deliveries due, in order. A delivery waiting for its
+// next attempt holds back the later ones to the same webhook. Nothing is
+// delivered while another process holds the outbox lock.
+func (n *Notifier) deliverDue(ctx context.Context, logger *slog.Logger) error {
+	lock, err := os.OpenFile(filepath.Join(n.cfg.Outbox, ".lock"), os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the outbox lock: %w", err)
+	}
+	// released when the file is closed
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			// delivered by the other process, looked at again on the next poll
+			return nil
+		}
+		return fmt.Errorf("failed to lock the outbox: %w", err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(n.cfg.Outbox, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	waiting := map[string]bool{}
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return nil
+		}
+		var d delivery
+		data, err := os.ReadFile(name)
+		if err == nil {
+			err = json.Unmarshal(data, &d)
+		}
+		if err != nil {
+			logger.Error("Dropping an invalid delivery", "file", filepath.Base(name), "error", err)
+			os.Remove(name)
+			continue
+		}
+		if !slices.Contains(n.cfg.URLs, d.URL) {
+			logger.Info("Dropping an event of a webhook no longer configured", "event", d.Event.Type, "delivery", d.Event.ID, "webhook", d.URL)
+			os.Remove(name)
+			continue
+		}
+		if waiting[d.URL] {
+			continue
+		}
+		if time.Now().Before(d.NextAttempt) {
+			waiting[d.URL] = true
+			continue
+		}
+
+		log := logger.With("event", d.Event.Type, "delivery", d.Event.ID, "webhook", d.URL)
+		err = n.send(ctx, d)
+		if ctx.Err() != nil {
+			// interrupted, attempted again on the next run
+			return nil
+		}
+		d.Attempts++
+		switch {
+		case err == nil:
+			log.Info("Event delivered", "attempts", d.Attempts)
+			os.Remove(name)
+		case !retryable(err) || (n.cfg.Retry.MaxAttempts > 0 && d.Attempts >= n.cfg.Retry.MaxAttempts):
+			log.Error("Dropping the event, it could not be delivered", "attempts", d.Attempts, "error", err)
+			os.Remove(name)
+		default:
+			d.LastError = err.Error()
+			d.NextAttempt = time.Now().Add(n.cfg.Retry.Backoff(d.Attempts))
+			log.Warn("Delivering the event failed", "attempts", d.Attempts, "retryAt", d.NextAttempt, "error", err)
+			if err := n.save(filepath.Base(name), d); err != nil {
+				log.Error("Failed to update the outbox", "error", err)
+			}
+			waiting[d.URL] = true
+		}
+	}
+	return nil
+}
+
+// send posts the event of d to its webhook.
+func (n *Notifier) send(ctx context.Context, d delivery) error {
+	body, err := json.Marshal(d.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Event.Type)
+	req.Header.Set(HeaderDelivery, d.Event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	if len(n.cfg.Secret) > 0 {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(n.cfg.Secret, timestamp, body))
+	}
+
+	resp, err := n.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+// StatusError is returned when a webhook answers with a status other than 2xx.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("webhook answered with status code %d", e.StatusCode)
+}
+
+// retryable reports whether a delivery that failed with err is attempted
+// again: network errors, timeouts, throttling and server errors are.
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusRequestTimeout || statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return httpclient.Retryable(err)
+}
+
+// save writes d to the outbox file name, replacing it atomically.
+func (n *Notifier) save(name string, d delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(n.cfg.Outbox, ".pending-")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(n.cfg.Outbox, name))
+}
+
+// Sign returns the hex HMAC-SHA256 of the delivery body sent at timestamp, for
+// the receivers to check the X-General-Service-Signature header.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReadSecret reads the secret signing the deliveries from path, trimming the
+// trailing newline. An empty path is no secret.
+func ReadSecret(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the webhook secret: %w", err)
+	}
+	secret = bytes.TrimRight(secret, "\r\n")
+	if len(secret) == 0 {
+		return nil, errors.New("the webhook secret is empty")
+	}
+	return secret, nil
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
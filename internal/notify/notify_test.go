@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/retry"
+)
+
+// webhooks records the deliveries to every path, failing the ones to the
+// paths in fail with the status code.
+type webhooks struct {
+	mu       sync.Mutex
+	received map[string][]string
+	fail     map[string]int
+	headers  []http.Header
+	bodies   [][]byte
+}
+
+func newWebhooks(t *testing.T) (*webhooks, *httptest.Server) {
+	t.Helper()
+	w := &webhooks{received: map[string][]string{}, fail: map[string]int{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if code := w.fail[r.URL.Path]; code != 0 {
+			rw.WriteHeader(code)
+			return
+		}
+		var e Event
+		json.Unmarshal(body, &e)
+		w.received[r.URL.Path] = append(w.received[r.URL.Path], e.Version)
+		w.headers = append(w.headers, r.Header.Clone())
+		w.bodies = append(w.bodies, body)
+	}))
+	t.Cleanup(srv.Close)
+	return w, srv
+}
+
+func (w *webhooks) setFail(path string, code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fail[path] = code
+}
+
+func (w *webhooks) got(path string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.received[path])
+}
+
+func newNotifier(t *testing.T, cfg Config) *Notifier {
+	t.Helper()
+	cfg.Outbox = filepath.Join(t.TempDir(), "outbox")
+	if cfg.Retry == (retry.Policy{}) {
+		cfg.Retry = retry.Policy{InitialInterval: time.Hour, MaxAttempts: 3}
+	}
+	n, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// pending returns the deliveries left in the outbox, making them due.
+func pending(t *testing.T, n *Notifier) []delivery {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(n.cfg.Outbox, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ds []delivery
+	for _, name := range names {
+		var d delivery
+		data, err := os.ReadFile(name)
+		if err == nil {
+			err = json.Unmarshal(data, &d)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+		d.NextAttempt = time.Time{}
+		if err := n.save(filepath.Base(name), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds
+}
+
+func deliver(t *testing.T, n *Notifier) {
+	t.Helper()
+	if err := n.deliverDue(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil))); err != nil {
+		t.Fatalf("deliverDue() error = %v", err)
+	}
+}
+
+func TestDeliveryOrder(t *testing.T) {
+	w, srv := newWebhooks(t)
+	n := newNotifier(t, Config{URLs: []string{srv.URL + "/a", srv.URL + "/b"}})
+
+	w.setFail("/a", http.StatusServiceUnavailable)
+	for _, v := range []string{"v1", "v2", "v3"} {
+		if err := n.Notify(Event{Type: EventApplied, Version: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deliver(t, n)
+
+	// the failed delivery holds back the later ones to the same webhook only
+	if got := w.got("/b"); !slices.Equal(got, []string{"v1", "v2", "v3"}) {
+		t.Errorf("delivered to b %v, want v1 v2 v3", got)
+	}
+	ds := pending(t, n)
+	if len(ds) != 3 || ds[0].Attempts != 1 || ds[1].Attempts != 0 || ds[0].LastError == "" {
+		t.Fatalf("pending = %+v, want the first delivery to a retried", ds)
+	}
+
+	w.setFail("/a", 0)
+	deliver(t, n)
+	if got := w.got("/a"); !slices.Equal(got, []string{"v1", "v2", "v3"}) {
+		t.Errorf("delivered to a %v, want v1 v2 v3", got)
+	}
+	if ds := pending(t, n); len(ds) != 0 {
+		t.Errorf("pending = %+v, want the outbox empty", ds)
+	}
+}
+
+func TestDeliveryDropped(t *testing.T) {
+	w, srv := newWebhooks(t)
+	n := newNotifier(t, Config{URLs: []string{srv.URL + "/a"}})
+
+	w.setFail("/a", http.StatusInternalServerError)
+	if err := n.Notify(Event{Type: EventFailed, Version: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := uint64(1); attempt <= 3; attempt++ {
+		deliver(t, n)
+		ds := pending(t, n)
+		if attempt < 3 && (len(ds) != 1 || ds[0].Attempts != attempt) {
+			t.Fatalf("after attempt %d pending = %+v", attempt, ds)
+		}
+		if attempt == 3 && len(ds) != 0 {
+			t.Fatalf("pending = %+v, want the delivery dropped after MaxAttempts", ds)
+		}
+	}
+
+	// client errors are not retried
+	w.setFail("/a", http.StatusBadRequest)
+	if err := n.Notify(Event{Type: EventFailed, Version: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, n)
+	if ds := pending(t, n); len(ds) != 0 {
+		t.Errorf("pending = %+v, want the delivery dropped", ds)
+	}
+}
+
+func TestDeliveryLocked(t *testing.T) {
+	w, srv := newWebhooks(t)
+	n := newNotifier(t, Config{URLs: []string{srv.URL + "/a"}})
+	if err := n.Notify(Event{Type: EventApplied, Version: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := os.OpenFile(filepath.Join(n.cfg.Outbox, ".lock"), os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, n)
+	if got := w.got("/a"); len(got) != 0 {
+		t.Errorf("delivered %v while the outbox is locked", got)
+	}
+
+	lock.Close()
+	deliver(t, n)
+	if got := w.got("/a"); !slices.Equal(got, []string{"v1"}) {
+		t.Errorf("delivered %v, want v1", got)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"id":"1"}' | openssl dgst -sha256 -hmac secret
+	const want = "086f6aff7bd084c98679825129c5a64dbad88c760016d6d2c0fb123f27951d54"
+	if got := Sign([]byte("secret"), "1700000000", []byte(`{"id":"1"}`)); got != want {
+		t.Errorf("Sign() = %s, want %s", got, want)
+	}
+
+	w, srv := newWebhooks(t)
+	n := newNotifier(t, Config{URLs: []string{srv.URL + "/a"}, Secret: []byte("secret")})
+	if err := n.Notify(Event{ID: "1", Type: EventApplied, Version: "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, n)
+	if len(w.headers) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(w.headers))
+	}
+	h := w.headers[0]
+	if h.Get(HeaderEvent) != EventApplied || h.Get(HeaderDelivery) != "1" {
+		t.Errorf("headers = %v, want the event and the delivery ID", h)
+	}
+	if got, want := h.Get(HeaderSignature), "sha256="+Sign([]byte("secret"), h.Get(HeaderTimestamp), w.bodies[0]); got != want {
+		t.Errorf("signature = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,524 @@
+// Package p2p shares verified artifacts between the hosts of a site, so that
+// only one of them downloads each release from the origin.
+//
+// Artifacts are served and requested by their SHA-256, and every artifact
+// fetched from a peer is checked against the size and the hash signed in TUF
+// before it is used, so peers do not need to be trusted with the content.
+// Peers are listed statically or discovered with mDNS.
+//
+// The artifacts are private, so they are only served to the hosts of the
+// subnets this host is attached to that prove they hold the token shared by the
+// site. The token itself is never sent, as the peers discovered with mDNS are
+// not trusted with it: every request carries an HMAC-SHA256 of the token over
+// the time, the method, the address of the peer and the path,
+//
+//	Authorization: GS-HMAC <unix seconds>:<hex HMAC>
+//
+// which is only accepted for a while and, when the address is an IP, by the
+// host owning it, so that a request relayed by a rogue peer is rejected.
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/mdns"
+)
+
+// ServiceName is the mDNS service advertised by the hosts sharing artifacts.
+const ServiceName = "_general-service-artifacts._tcp"
+
+// AuthScheme is the scheme of the Authorization header of the requests.
+const AuthScheme = "GS-HMAC"
+
+const (
+	defaultMaxCached = 2
+	discoveryTimeout = time.Second
+	// maxClockSkew bounds the age of the requests accepted.
+	maxClockSkew = time.Minute
+
+	// A peer is skipped when it does not answer quickly or stops sending,
+	// so that a slow one does not hold back the download from the origin.
+	peerDialTimeout   = 2 * time.Second
+	peerHeaderTimeout = 3 * time.Second
+	peerIdleTimeout   = 5 * time.Second
+	peerTimeout       = 2 * time.Minute
+)
+
+var (
+	// ErrNotFound is returned when no peer could provide a valid artifact.
+	ErrNotFound = errors.New("artifact not available from any peer")
+	// ErrNoToken is returned when no token is configured to serve the
+	// artifacts.
+	ErrNoToken = errors.New("no token to authenticate the peers")
+)
+
+var hashRegex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// Config holds the peer-to-peer settings.
+type Config struct {
+	// Addr is where the verified artifacts are served, e.g. ":8765".
+	Addr string
+	// Token authenticates the peers, which sign their requests with it. It is
+	// required to serve the artifacts.
+	Token []byte
+	// CacheDir holds the verified artifacts, named after their SHA-256.
+	CacheDir string
+	// Peers are "host:port" addresses of other hosts.
+	Peers []string
+	// MDNS advertises this host and discovers peers with mDNS.
+	MDNS bool
+	// MaxCached is the number of artifacts kept in CacheDir.
+	MaxCached int
+}
+
+// Node serves the verified artifacts of this host and fetches the ones it
+// misses from its peers.
+type Node struct {
+	cfg     Config
+	client  *http.Client
+	handler http.Handler
+	srv     *http.Server
+	mdns    *mdns.Server
+}
+
+// New creates a node. Nothing is served until Start is called.
+func New(cfg Config) (*Node, error) {
+	if cfg.MaxCached <= 0 {
+		cfg.MaxCached = defaultMaxCached
+	}
+	if err := os.MkdirAll(cfg.CacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the artifact cache: %w", err)
+	}
+
+	n := &Node{
+		cfg: cfg,
+		// peers are on the LAN: no proxy, and the content is verified anyway
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:           (&net.Dialer{Timeout: peerDialTimeout}).DialContext,
+				ResponseHeaderTimeout: peerHeaderTimeout,
+			},
+			Timeout: peerTimeout,
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /artifacts/{sha256}", n.serveArtifact)
+	n.handler = n.authenticate(mux)
+	n.srv = &http.Server{Addr: cfg.Addr, Handler: n.handler, ReadHeaderTimeout: 10 * time.Second}
+	return n, nil
+}
+
+// Start serves the artifacts to the authenticated peers of the LAN and, when
+// enabled, advertises them with mDNS.
+func (n *Node) Start() error {
+	if len(n.cfg.Token) == 0 {
+		return ErrNoToken
+	}
+	ln, err := net.Listen("tcp", n.cfg.Addr)
+	if err != nil {
+		return err
+	}
+
+	if n.cfg.MDNS {
+		if err := n.advertise(ln.Addr().(*net.TCPAddr).Port); err != nil {
+			ln.Close()
+			return fmt.Errorf("failed to advertise with mDNS: %w", err)
+		}
+	}
+
+	go n.srv.Serve(ln)
+	return nil
+}
+
+// Close stops serving and advertising the artifacts.
+func (n *Node) Close() error {
+	if n.mdns != nil {
+		n.mdns.Shutdown()
+	}
+	return n.srv.Close()
+}
+
+// Handler returns the handler serving the artifacts to the authenticated
+// peers, for nodes served along with other content instead of with Start.
+// Nothing is served without a token.
+func (n *Node) Handler() http.Handler {
+	return n.handler
+}
+
+// authenticate only lets through the requests of the hosts of the local
+// subnets signed with the token, for this host.
+func (n *Node) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !local(r.RemoteAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if len(n.cfg.Token) == 0 || !verify(n.cfg.Token, r, time.Now()) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !own(r.Host) {
+			http.Error(w, "misdirected request", http.StatusMisdirectedRequest)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authorization returns the Authorization header of a request to the peer at
+// host, signed with token at t.
+func Authorization(token []byte, method, host, path string, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return AuthScheme + " " + timestamp + ":" + sign(token, timestamp, method, host, path)
+}
+
+func sign(token []byte, timestamp, method, host, path string) string {
+	mac := hmac.New(sha256.New, token)
+	for _, s := range []string{timestamp, method, host, path} {
+		mac.Write([]byte(s))
+		mac.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether r is signed with token and recent at now.
+func verify(token []byte, r *http.Request, now time.Time) bool {
+	credentials, ok := strings.CutPrefix(r.Header.Get("Authorization"), AuthScheme+" ")
+	if !ok {
+		return false
+	}
+	timestamp, signature, ok := strings.Cut(credentials, ":")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return false
+	}
+	want := sign(token, timestamp, r.Method, r.Host, r.URL.Path)
+	return subtle.ConstantTimeCompare([]byte(signature), []byte(want)) == 1
+}
+
+// local reports whether the host at addr is on one of the subnets of the
+// interfaces of this host.
+func local(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, subnet := range interfaceSubnets() {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// own reports whether the request for host was sent to this host. Names are
+// only used for the static peers, which are trusted, so only IPs are checked.
+func own(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return true
+	}
+	for _, subnet := range interfaceSubnets() {
+		if subnet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func interfaceSubnets() []*net.IPNet {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var subnets []*net.IPNet
+	for _, a := range addrs {
+		if subnet, ok := a.(*net.IPNet); ok {
+			subnets = append(subnets, subnet)
+		}
+	}
+	return subnets
+}
+
+// ReadToken reads the token shared by the peers from path, trimming the
+// trailing newline. An empty path returns ErrNoToken.
+func ReadToken(path string) ([]byte, error) {
+	if path == "" {
+		return nil, ErrNoToken
+	}
+	token, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the peer token: %w", err)
+	}
+	token = bytes.TrimRight(token, "\r\n")
+	if len(token) == 0 {
+		return nil, errors.New("the peer token is empty")
+	}
+	return token, nil
+}
+
+// Has reports whether the artifact with SHA-256 sum is in the cache.
+func (n *Node) Has(sum string) bool {
+	sum = strings.ToLower(sum)
+	if !hashRegex.MatchString(sum) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(n.cfg.CacheDir, sum))
+	return err == nil
+}
+
+func (n *Node) advertise(port int) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	svc, err := mdns.NewMDNSService(host, ServiceName, "", "", port, nil, []string{"general-service"})
+	if err != nil {
+		return err
+	}
+	n.mdns, err = mdns.NewServer(&mdns.Config{Zone: svc})
+	return err
+}
+
+func (n *Node) serveArtifact(w http.ResponseWriter, r *http.Request) {
+	sum := r.PathValue("sha256")
+	if !hashRegex.MatchString(sum) {
+		http.Error(w, "invalid hash", http.StatusBadRequest)
+		return
+	}
+	path := filepath.Join(n.cfg.CacheDir, sum)
+	if _, err := os.Stat(path); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeFile(w, r, path)
+}
+
+// Store copies the artifact at path, already verified against sum, into the
+// cache so that peers can fetch it. Only the latest artifacts are kept.
+func (n *Node) Store(path, sum string) error {
+	sum = strings.ToLower(sum)
+	if !hashRegex.MatchString(sum) {
+		return fmt.Errorf("invalid hash %q", sum)
+	}
+	dest := filepath.Join(n.cfg.CacheDir, sum)
+	if _, err := os.Stat(dest); err == nil {
+		return n.touch(dest)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := writeVerified(in, dest, sum, info.Size()); err != nil {
+		return err
+	}
+	return n.prune()
+}
+
+// Fetch writes the artifact with SHA-256 sum and size length to dest, trying
+// every peer until one provides content matching both. No more than length
+// bytes are downloaded from each peer, and the peers slow to answer or that
+// stop sending are skipped.
+func (n *Node) Fetch(ctx context.Context, sum string, length int64, dest string) error {
+	sum = strings.ToLower(sum)
+	if !hashRegex.MatchString(sum) {
+		return fmt.Errorf("invalid hash %q", sum)
+	}
+	if length <= 0 {
+		return fmt.Errorf("invalid length %d", length)
+	}
+
+	var errs []error
+	for _, peer := range n.peers() {
+		err := n.fetchFrom(ctx, peer, sum, length, dest)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", peer, err))
+	}
+	return fmt.Errorf("%w: %w", ErrNotFound, errors.Join(errs...))
+}
+
+func (n *Node) fetchFrom(ctx context.Context, peer, sum string, length int64, dest string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+peer+"/artifacts/"+sum, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", Authorization(n.cfg.Token, req.Method, req.URL.Host, req.URL.Path, time.Now()))
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+
+	body := &idleReader{r: res.Body, timer: time.AfterFunc(peerIdleTimeout, cancel)}
+	defer body.timer.Stop()
+	err = writeVerified(body, dest, sum, length)
+	if err != nil && body.stalled() {
+		return fmt.Errorf("peer stopped sending for %s", peerIdleTimeout)
+	}
+	return err
+}
+
+// idleReader fires its timer when no byte is read for peerIdleTimeout.
+type idleReader struct {
+	r     io.Reader
+	timer *time.Timer
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(peerIdleTimeout)
+	}
+	return n, err
+}
+
+// stalled reports whether the timer fired.
+func (r *idleReader) stalled() bool {
+	return !r.timer.Stop()
+}
+
+// peers returns the static peers followed by the ones discovered with mDNS.
+func (n *Node) peers() []string {
+	peers := append([]string{}, n.cfg.Peers...)
+	if !n.cfg.MDNS {
+		return peers
+	}
+
+	entries := make(chan *mdns.ServiceEntry, 16)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range entries {
+			if e.AddrV4 != nil {
+				peers = append(peers, net.JoinHostPort(e.AddrV4.String(), strconv.Itoa(e.Port)))
+			}
+		}
+	}()
+
+	params := mdns.DefaultParams(ServiceName)
+	params.Entries = entries
+	params.Timeout = discoveryTimeout
+	params.DisableIPv6 = true
+	_ = mdns.Query(params)
+	close(entries)
+	<-done
+
+	return peers
+}
+
+// writeVerified writes r to dest only if its content matches sum and length,
+// reading no more than length bytes past which it is rejected. A temporary file
+// is used, so that dest is never left with unverified content.
+func writeVerified(r io.Reader, dest, sum string, length int64) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".p2p-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, length+1))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if n > length {
+		return fmt.Errorf("size mismatch: more than the %d bytes expected", length)
+	}
+	if n < length {
+		return fmt.Errorf("size mismatch: got %d bytes, expected %d", n, length)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return fmt.Errorf("hash mismatch: got %s, expected %s", got, sum)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+func (n *Node) touch(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
+}
+
+// prune removes the least recently stored artifacts above MaxCached.
+func (n *Node) prune() error {
+	entries, err := os.ReadDir(n.cfg.CacheDir)
+	if err != nil {
+		return err
+	}
+
+	type cached struct {
+		path string
+		mod  time.Time
+	}
+	var files []cached
+	for _, e := range entries {
+		if !hashRegex.MatchString(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{filepath.Join(n.cfg.CacheDir, e.Name()), info.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].mod.After(files[j].mod) })
+	for i := n.cfg.MaxCached; i < len(files); i++ {
+		if err := os.Remove(files[i].path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var token = []byte("site token")
+
+const artifact = "the artifact of the release"
+
+func newNode(t *testing.T, cfg Config) *Node {
+	t.Helper()
+	cfg.CacheDir = filepath.Join(t.TempDir(), "cache")
+	n, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// serve stores the artifact in a node with the token and serves it.
+func serve(t *testing.T, token []byte) (*Node, *httptest.Server, string) {
+	t.Helper()
+	n := newNode(t, Config{Token: token})
+	path := filepath.Join(t.TempDir(), "artifact.zip")
+	if err := os.WriteFile(path, []byte(artifact), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256([]byte(artifact))
+	sum := hex.EncodeToString(h[:])
+	if err := n.Store(path, sum); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(n.Handler())
+	t.Cleanup(srv.Close)
+	return n, srv, sum
+}
+
+func TestAuthenticate(t *testing.T) {
+	_, srv, sum := serve(t, token)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	path := "/artifacts/" + sum
+	now := time.Now()
+
+	tests := []struct {
+		name          string
+		host          string
+		authorization string
+		want          int
+	}{
+		{
+			name:          "signed",
+			authorization: Authorization(token, http.MethodGet, host, path, now),
+			want:          http.StatusOK,
+		},
+		{
+			name: "unsigned",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:          "bearer token",
+			authorization: "Bearer " + string(token),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "other token",
+			authorization: Authorization([]byte("other token"), http.MethodGet, host, path, now),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "other artifact",
+			authorization: Authorization(token, http.MethodGet, host, "/artifacts/"+strings.Repeat("0", 64), now),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "expired",
+			authorization: Authorization(token, http.MethodGet, host, path, now.Add(-2*maxClockSkew)),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			name:          "malformed",
+			authorization: AuthScheme + " " + sign(token, "now", http.MethodGet, host, path),
+			want:          http.StatusUnauthorized,
+		},
+		{
+			// signed for a rogue peer relaying it
+			name:          "relayed",
+			host:          "192.0.2.1:8765",
+			authorization: Authorization(token, http.MethodGet, "192.0.2.1:8765", path, now),
+			want:          http.StatusMisdirectedRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeWithoutToken(t *testing.T) {
+	n, srv, sum := serve(t, nil)
+	if err := n.Start(); !errors.Is(err, ErrNoToken) {
+		t.Errorf("Start() error = %v, want ErrNoToken", err)
+	}
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/artifacts/"+sum, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", Authorization(nil, http.MethodGet, host, req.URL.Path, time.Now()))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	_, srv, sum := serve(t, token)
+	peer := strings.TrimPrefix(srv.URL, "http://")
+	dest := filepath.Join(t.TempDir(), "artifact.zip")
+
+	n := newNode(t, Config{Token: token, Peers: []string{peer}})
+	if err := n.Fetch(context.Background(), sum, int64(len(artifact)), dest); err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got, err := os.ReadFile(dest); err != nil || string(got) != artifact {
+		t.Errorf("fetched %q, %v, want %q", got, err, artifact)
+	}
+
+	tests := []struct {
+		name   string
+		token  []byte
+		length int64
+	}{
+		{name: "other token", token: []byte("other token"), length: int64(len(artifact))},
+		{name: "shorter", token: token, length: int64(len(artifact)) - 1},
+		{name: "longer", token: token, length: int64(len(artifact)) + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "artifact.zip")
+			n := newNode(t, Config{Token: tt.token, Peers: []string{peer}})
+			if err := n.Fetch(context.Background(), sum, tt.length, dest); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Fetch() error = %v, want ErrNotFound", err)
+			}
+			if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("unverified artifact written")
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Package pin holds a service at a known-good version and keeps it away from
+// releases known to be broken.
+//
+// A pin is either an exact version, "v2025.03.01-sha.1a2b3c4", or a range made
+// of space separated comparisons, ">=v2025.03.01 <v2025.04.01". Bounds may omit
+// the trailing parts of the tag, down to the year, so that "v2025.03.01"
+// matches every release of that day.
+package pin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/version"
+)
+
+var (
+	// ErrBlocked is returned for versions in the blocklist.
+	ErrBlocked = errors.New("version is blocklisted")
+	// ErrNotPinned is returned for versions outside the pin.
+	ErrNotPinned = errors.New("version does not match the pin")
+)
+
+// Constraint is a parsed pin. The zero Constraint matches every version.
+type Constraint struct {
+	spec        string
+	comparisons []comparison
+}
+
+type comparison struct {
+	op      string
+	version version.Version
+	// layout is set for the bounds without the commit, that only compare the
+	// release day, month or year
+	layout string
+	date   time.Time
+}
+
+// operators sorted so that the two-character ones are tried first
+var operators = []string{">=", "<=", ">", "<", "="}
+
+// layouts of the bounds that omit the trailing parts of the tag
+var layouts = []string{"v2006.01.02", "v2006.01", "v2006"}
+
+// compare orders v and the bound of the comparison.
+func (c comparison) compare(v version.Version) int {
+	if c.layout == "" {
+		return v.Compare(c.version)
+	}
+	date, _ := time.Parse(c.layout, v.Date().Format(c.layout))
+	return date.Compare(c.date)
+}
+
+// Parse parses a pin. An empty spec returns the zero Constraint.
+func Parse(spec string) (Constraint, error) {
+	spec = strings.TrimSpace(spec)
+	c := Constraint{spec: spec}
+
+	for _, field := range strings.Fields(spec) {
+		cmp := comparison{op: "="}
+		for _, op := range operators {
+			if strings.HasPrefix(field, op) {
+				cmp.op = op
+				field = field[len(op):]
+				break
+			}
+		}
+		if v, err := version.Parse(field); err == nil {
+			cmp.version = v
+		} else {
+			for _, layout := range layouts {
+				if date, err := time.Parse(layout, field); err == nil {
+					cmp.layout, cmp.date = layout, date
+					break
+				}
+			}
+			if cmp.layout == "" {
+				return Constraint{}, fmt.Errorf("invalid pin %q: %q is not a version", spec, field)
+			}
+		}
+		c.comparisons = append(c.comparisons, cmp)
+	}
+	return c, nil
+}
+
+// String returns the pin as it was parsed.
+func (c Constraint) String() string {
+	return c.spec
+}
+
+// IsZero reports whether the constraint matches every version.
+func (c Constraint) IsZero() bool {
+	return len(c.comparisons) == 0
+}
+
+// Match reports whether tag satisfies every comparison of the pin. A tag that
+// is not a release tag only matches the zero Constraint.
+func (c Constraint) Match(tag string) bool {
+	if c.IsZero() {
+		return true
+	}
+	v, err := version.Parse(tag)
+	if err != nil {
+		return false
+	}
+	for _, cmp := range c.comparisons {
+		n := cmp.compare(v)
+		var ok bool
+		switch cmp.op {
+		case "=":
+			ok = n == 0
+		case ">=":
+			ok = n >= 0
+		case "<=":
+			ok = n <= 0
+		case ">":
+			ok = n > 0
+		case "<":
+			ok = n < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Rules combines a pin and a blocklist.
+type Rules struct {
+	Pin       Constraint
+	Blocklist []string
+}
+
+// NewRules parses the pin and the blocklisted versions.
+func NewRules(pin string, blocklist []string) (Rules, error) {
+	c, err := Parse(pin)
+	if err != nil {
+		return Rules{}, err
+	}
+	for _, v := range blocklist {
+		if !version.IsTag(v) {
+			return Rules{}, fmt.Errorf("invalid blocklisted version %q", v)
+		}
+	}
+	return Rules{Pin: c, Blocklist: blocklist}, nil
+}
+
+// Check returns an error wrapping ErrBlocked or ErrNotPinned if version must
+// not be installed.
+func (r Rules) Check(version string) error {
+	for _, v := range r.Blocklist {
+		if v == version {
+			return fmt.Errorf("%w: %s", ErrBlocked, version)
+		}
+	}
+	if !r.Pin.Match(version) {
+		return fmt.Errorf("%w: %s is outside %q", ErrNotPinned, version, r.Pin)
+	}
+	return nil
+}
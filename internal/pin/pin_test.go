@@ -0,0 +1,108 @@
+package pin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		spec  string
+		match []string
+		miss  []string
+	}{
+		{
+			spec:  "",
+			match: []string{"v2025.03.01-sha.1a2b3c4", "dev"},
+		},
+		{
+			spec:  "v2025.03.01-sha.1a2b3c4",
+			match: []string{"v2025.03.01-sha.1a2b3c4"},
+			miss:  []string{"v2025.03.01-sha.fffffff", "v2025.03.02-sha.1a2b3c4", "dev"},
+		},
+		{
+			spec:  "v2025.03.01",
+			match: []string{"v2025.03.01-sha.1a2b3c4", "v2025.03.01-sha.fffffff"},
+			miss:  []string{"v2025.02.28-sha.1a2b3c4", "v2025.03.02-sha.1a2b3c4"},
+		},
+		{
+			spec:  "v2025.03",
+			match: []string{"v2025.03.01-sha.1a2b3c4", "v2025.03.31-sha.1a2b3c4"},
+			miss:  []string{"v2025.02.28-sha.1a2b3c4", "v2025.04.01-sha.1a2b3c4"},
+		},
+		{
+			spec:  ">=v2025.03.01 <v2025.04.01",
+			match: []string{"v2025.03.01-sha.1a2b3c4", "v2025.03.31-sha.1a2b3c4"},
+			miss:  []string{"v2025.02.28-sha.1a2b3c4", "v2025.04.01-sha.1a2b3c4", "dev"},
+		},
+		{
+			spec:  ">v2025.03 <=v2025.05",
+			match: []string{"v2025.04.01-sha.1a2b3c4", "v2025.05.31-sha.1a2b3c4"},
+			miss:  []string{"v2025.03.31-sha.1a2b3c4", "v2025.06.01-sha.1a2b3c4"},
+		},
+		{
+			spec:  "<v2026",
+			match: []string{"v2025.12.31-sha.1a2b3c4"},
+			miss:  []string{"v2026.01.01-sha.1a2b3c4"},
+		},
+		{
+			spec:  ">=v2025.03.01-sha.1a2b3c4 <=v2025.03.02",
+			match: []string{"v2025.03.01-sha.1a2b3c4", "v2025.03.02-sha.0000000"},
+			miss:  []string{"v2025.02.28-sha.fffffff", "v2025.03.03-sha.0000000"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			c, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if c.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", c, tt.spec)
+			}
+			for _, v := range tt.match {
+				if !c.Match(v) {
+					t.Errorf("Match(%q) = false", v)
+				}
+			}
+			for _, v := range tt.miss {
+				if c.Match(v) {
+					t.Errorf("Match(%q) = true", v)
+				}
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"latest", ">=v2025.03.01 <next", "~v2025.03", "v2025.13"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	if _, err := NewRules("", []string{"v2025.03"}); err == nil {
+		t.Error("NewRules() accepted a blocklisted version that is not a tag")
+	}
+
+	rules, err := NewRules(">=v2025.03", []string{"v2025.03.02-sha.1a2b3c4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		version string
+		want    error
+	}{
+		{"v2025.03.01-sha.1a2b3c4", nil},
+		{"v2025.03.02-sha.1a2b3c4", ErrBlocked},
+		{"v2025.02.28-sha.1a2b3c4", ErrNotPinned},
+	}
+	for _, tt := range tests {
+		if err := rules.Check(tt.version); !errors.Is(err, tt.want) {
+			t.Errorf("Check(%q) error = %v, want %v", tt.version, err, tt.want)
+		}
+	}
+}
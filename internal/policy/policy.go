@@ -0,0 +1,228 @@
+// Package policy decides when an available update is applied without anyone
+// clicking the update button.
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Mode is the kind of update policy.
+type Mode string
+
+const (
+	// Manual updates are only applied when the user requests them.
+	Manual Mode = "manual"
+	// Immediate updates are applied as soon as they are available.
+	Immediate Mode = "immediate"
+	// Window updates are applied inside a maintenance window.
+	Window Mode = "window"
+	// Soak updates are applied once a delay since their release has elapsed.
+	Soak Mode = "soak"
+)
+
+// Policy is the update policy of a service.
+type Policy struct {
+	Mode Mode
+	// Days, Start and End describe the maintenance window of the Window mode:
+	// from Start to End, as offsets from midnight in Location, on Days. A
+	// window ending before it starts goes past midnight.
+	Days     []time.Weekday
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+	// SoakDelay is the time to wait since the release in the Soak mode.
+	SoakDelay time.Duration
+}
+
+// Parse parses a policy spec, one of:
+//
+//	manual
+//	immediate
+//	soak:<duration>                            e.g. soak:72h
+//	window:<days> <HH:MM>-<HH:MM> [<timezone>] e.g. window:mon-fri 02:00-05:00 Europe/Madrid
+//
+// Days are a comma separated list of days or day ranges; "*" means every day.
+// The timezone defaults to the local one.
+func Parse(spec string) (Policy, error) {
+	mode, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+
+	switch Mode(strings.ToLower(mode)) {
+	case Manual, "":
+		return Policy{Mode: Manual}, nil
+	case Immediate:
+		return Policy{Mode: Immediate}, nil
+	case Soak:
+		delay, err := time.ParseDuration(args)
+		if err != nil || delay < 0 {
+			return Policy{}, fmt.Errorf("invalid soak delay %q", args)
+		}
+		return Policy{Mode: Soak, SoakDelay: delay}, nil
+	case Window:
+		return parseWindow(args)
+	default:
+		return Policy{}, fmt.Errorf("unknown update policy %q", mode)
+	}
+}
+
+// String returns the spec of the policy.
+func (p Policy) String() string {
+	switch p.Mode {
+	case Soak:
+		return fmt.Sprintf("soak:%s", p.SoakDelay)
+	case Window:
+		days := make([]string, len(p.Days))
+		for i, d := range p.Days {
+			days[i] = strings.ToLower(d.String()[:3])
+		}
+		return fmt.Sprintf("window:%s %s-%s %s", strings.Join(days, ","), clock(p.Start), clock(p.End), p.Location)
+	case "":
+		return string(Manual)
+	default:
+		return string(p.Mode)
+	}
+}
+
+// NextApply returns when an update released at release can be applied
+// automatically, given that it is available since now. The returned time is
+// never before now. The boolean is false if the update is never applied
+// automatically.
+func (p Policy) NextApply(release, now time.Time) (time.Time, bool) {
+	switch p.Mode {
+	case Immediate:
+		return now, true
+	case Soak:
+		if due := release.Add(p.SoakDelay); due.After(now) {
+			return due, true
+		}
+		return now, true
+	case Window:
+		return p.nextWindow(now), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// Due reports whether an update released at release must be applied now.
+func (p Policy) Due(release, now time.Time) bool {
+	next, ok := p.NextApply(release, now)
+	return ok && !next.After(now)
+}
+
+// nextWindow returns now if it is inside the maintenance window, or the start
+// of the next one.
+func (p Policy) nextWindow(now time.Time) time.Time {
+	local := now.In(p.Location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, p.Location)
+
+	// a window starting yesterday may still be open
+	for offset := -1; offset <= 7; offset++ {
+		day := midnight.AddDate(0, 0, offset)
+		if !p.onDay(day.Weekday()) {
+			continue
+		}
+		start := day.Add(p.Start)
+		end := day.Add(p.End)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !now.Before(start) && now.Before(end) {
+			return now
+		}
+		if start.After(now) {
+			return start
+		}
+	}
+	return now
+}
+
+func (p Policy) onDay(d time.Weekday) bool {
+	if len(p.Days) == 0 {
+		return true
+	}
+	for _, day := range p.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+func parseWindow(args string) (Policy, error) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Policy{}, fmt.Errorf("invalid window %q: expected <days> <HH:MM>-<HH:MM> [<timezone>]", args)
+	}
+
+	p := Policy{Mode: Window, Location: time.Local}
+
+	days, err := parseDays(fields[0])
+	if err != nil {
+		return Policy{}, err
+	}
+	p.Days = days
+
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return Policy{}, fmt.Errorf("invalid window hours %q", fields[1])
+	}
+	if p.Start, err = parseClock(from); err != nil {
+		return Policy{}, err
+	}
+	if p.End, err = parseClock(to); err != nil {
+		return Policy{}, err
+	}
+
+	if len(fields) == 3 {
+		if p.Location, err = time.LoadLocation(fields[2]); err != nil {
+			return Policy{}, fmt.Errorf("invalid window timezone: %w", err)
+		}
+	}
+	return p, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseDays(s string) ([]time.Weekday, error) {
+	if s == "*" {
+		return nil, nil
+	}
+
+	var days []time.Weekday
+	for _, item := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(item, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return nil, fmt.Errorf("invalid day %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func clock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+}
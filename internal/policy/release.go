@@ -0,0 +1,25 @@
+package policy
+
+import (
+	"fmt"
+	"time"
+)
+
+// releaseDateLayouts are the formats accepted for the release-date of an index.
+var releaseDateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006.01.02",
+}
+
+// ParseReleaseDate parses the release-date field of a service index.
+func ParseReleaseDate(s string) (time.Time, error) {
+	for _, layout := range releaseDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid release date %q", s)
+}
@@ -0,0 +1,42 @@
+package policy
+
+import "strings"
+
+// Set holds the policy of every service, with a default for the services
+// without one.
+type Set struct {
+	Default  Policy
+	Services map[string]Policy
+}
+
+// ParseSet parses a list of "<service>=<spec>" entries. An entry without a
+// service sets the default policy.
+func ParseSet(entries []string) (Set, error) {
+	set := Set{Default: Policy{Mode: Manual}, Services: make(map[string]Policy)}
+
+	for _, entry := range entries {
+		// policy specs never contain "="
+		service, spec, found := strings.Cut(entry, "=")
+		if !found {
+			spec = entry
+		}
+		p, err := Parse(spec)
+		if err != nil {
+			return Set{}, err
+		}
+		if !found {
+			set.Default = p
+			continue
+		}
+		set.Services[service] = p
+	}
+	return set, nil
+}
+
+// For returns the policy of service.
+func (s Set) For(service string) Policy {
+	if p, ok := s.Services[service]; ok {
+		return p
+	}
+	return s.Default
+}
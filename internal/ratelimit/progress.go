@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Report describes the progress of a download.
+type Report struct {
+	Done  int64
+	Total int64
+	// Rate is the average bytes per second since the download started.
+	Rate float64
+	// Limit is the effective limit in bytes per second, 0 for no limit.
+	Limit uint64
+}
+
+func (r Report) String() string {
+	limit := "no limit"
+	if r.Limit > 0 {
+		limit = "limit " + formatSize(float64(r.Limit)) + "/s"
+	}
+	if r.Total > 0 {
+		return fmt.Sprintf("%s of %s at %s/s (%s)", formatSize(float64(r.Done)), formatSize(float64(r.Total)), formatSize(r.Rate), limit)
+	}
+	return fmt.Sprintf("%s at %s/s (%s)", formatSize(float64(r.Done)), formatSize(r.Rate), limit)
+}
+
+func formatSize(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+// Progress returns r calling report at most every interval and once at the
+// end of the content. The effective limit is the lowest of the limiters.
+func Progress(r io.Reader, total int64, every time.Duration, report func(Report), limiters ...*Limiter) io.Reader {
+	now := time.Now()
+	return &progress{r: r, total: total, every: every, report: report, limiters: limiters, start: now, last: now}
+}
+
+type progress struct {
+	r        io.Reader
+	total    int64
+	every    time.Duration
+	report   func(Report)
+	limiters []*Limiter
+
+	done        int64
+	start, last time.Time
+}
+
+func (p *progress) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+
+	now := time.Now()
+	if err == io.EOF || now.Sub(p.last) >= p.every {
+		p.last = now
+		p.report(p.snapshot(now))
+	}
+	return n, err
+}
+
+func (p *progress) snapshot(now time.Time) Report {
+	r := Report{Done: p.done, Total: p.total}
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		r.Rate = float64(p.done) / elapsed
+	}
+	for _, l := range p.limiters {
+		if limit := l.Rate(now); limit > 0 && (r.Limit == 0 || limit < r.Limit) {
+			r.Limit = limit
+		}
+	}
+	return r
+}
@@ -0,0 +1,224 @@
+// Package ratelimit throttles downloads so that they do not saturate the
+// uplink of the site. The rate may depend on the time of day, e.g. to leave
+// the link to the door controllers during working hours.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxChunk bounds the bytes read at once, so that a slow limit is applied
+// smoothly instead of in large bursts. It is also the default burst.
+const maxChunk = 32 * 1024
+
+// Config holds the rate limits.
+type Config struct {
+	// Rate is the maximum bytes per second, 0 for no limit.
+	Rate uint64
+	// Burst is the maximum bytes read at once above the rate.
+	Burst uint64
+	// Windows override Rate at some times of the day.
+	Windows []Window
+}
+
+// Window is a time of day, in local time, with its own rate.
+type Window struct {
+	Start, End time.Duration
+	Rate       uint64
+}
+
+// ParseConfig parses the rate and the burst, sizes such as "512K" or "2M",
+// and the windows, "<HH:MM>-<HH:MM>=<rate>".
+func ParseConfig(rateSpec, burstSpec string, windowSpecs []string) (Config, error) {
+	var cfg Config
+	var err error
+	if cfg.Rate, err = ParseSize(rateSpec); err != nil {
+		return Config{}, err
+	}
+	if cfg.Burst, err = ParseSize(burstSpec); err != nil {
+		return Config{}, err
+	}
+	for _, spec := range windowSpecs {
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.Windows = append(cfg.Windows, w)
+	}
+	return cfg, nil
+}
+
+// ParseSize parses a number of bytes with an optional K, M or G suffix, as
+// powers of 1024. An empty size is 0. Sizes above math.MaxInt64 are rejected.
+func ParseSize(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	spec := s
+	mult := uint64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("size %q is too large", spec)
+	}
+	return n * mult, nil
+}
+
+// ParseWindow parses "<HH:MM>-<HH:MM>=<rate>". Windows ending before they
+// start span midnight.
+func ParseWindow(spec string) (Window, error) {
+	hours, rateSpec, ok := strings.Cut(spec, "=")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid rate window %q, expected <HH:MM>-<HH:MM>=<rate>", spec)
+	}
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("invalid rate window %q, expected <HH:MM>-<HH:MM>=<rate>", spec)
+	}
+
+	var w Window
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return Window{}, fmt.Errorf("invalid rate window %q: %w", spec, err)
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return Window{}, fmt.Errorf("invalid rate window %q: %w", spec, err)
+	}
+	if w.Rate, err = ParseSize(rateSpec); err != nil {
+		return Window{}, fmt.Errorf("invalid rate window %q: %w", spec, err)
+	}
+	return w, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether the time of day of t is in the window.
+func (w Window) Contains(t time.Time) bool {
+	y, m, d := t.Date()
+	clock := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.Start <= w.End {
+		return clock >= w.Start && clock < w.End
+	}
+	return clock >= w.Start || clock < w.End
+}
+
+// Limiter limits the throughput of the readers it wraps. A nil Limiter does
+// not limit anything.
+type Limiter struct {
+	cfg Config
+	lim *rate.Limiter
+
+	mu      sync.Mutex
+	applied bool
+	current uint64
+}
+
+// New returns a limiter allowing bytesPerSecond, or nil when bytesPerSecond
+// is 0.
+func New(bytesPerSecond uint64) *Limiter {
+	return NewWithConfig(Config{Rate: bytesPerSecond})
+}
+
+// NewWithConfig returns a limiter applying cfg, or nil when cfg does not
+// limit anything.
+func NewWithConfig(cfg Config) *Limiter {
+	if cfg.Rate == 0 && len(cfg.Windows) == 0 {
+		return nil
+	}
+	burst := int(cfg.Burst)
+	if burst <= 0 {
+		burst = maxChunk
+	}
+	l := &Limiter{cfg: cfg, lim: rate.NewLimiter(rate.Inf, burst)}
+	l.update(time.Now())
+	return l
+}
+
+// Rate returns the bytes per second allowed at t, 0 for no limit.
+func (l *Limiter) Rate(t time.Time) uint64 {
+	if l == nil {
+		return 0
+	}
+	for _, w := range l.cfg.Windows {
+		if w.Contains(t) {
+			return w.Rate
+		}
+	}
+	return l.cfg.Rate
+}
+
+// update applies the rate of the time of day t.
+func (l *Limiter) update(t time.Time) {
+	r := l.Rate(t)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.applied && r == l.current {
+		return
+	}
+	l.applied = true
+	l.current = r
+	if r == 0 {
+		l.lim.SetLimitAt(t, rate.Inf)
+		return
+	}
+	l.lim.SetLimitAt(t, rate.Limit(r))
+}
+
+// Reader returns r limited by l. The limiter is shared by all the readers it
+// wraps.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, l: l}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	r.l.update(time.Now())
+	if burst := r.l.lim.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.l.lim.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
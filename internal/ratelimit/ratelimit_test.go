@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    uint64
+		wantErr bool
+	}{
+		{spec: "", want: 0},
+		{spec: "512", want: 512},
+		{spec: "512K", want: 512 << 10},
+		{spec: " 2m ", want: 2 << 20},
+		{spec: "1G", want: 1 << 30},
+		{spec: "8589934591G", want: 8589934591 << 30},
+		{spec: "8589934592G", wantErr: true},
+		{spec: "17179869184G", wantErr: true},
+		{spec: "18446744073709551615", wantErr: true},
+		{spec: "-1K", wantErr: true},
+		{spec: "2T", wantErr: true},
+		{spec: "K", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSize(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWindow(t *testing.T) {
+	w, err := ParseWindow("22:30-06:00=2M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Window{Start: 22*time.Hour + 30*time.Minute, End: 6 * time.Hour, Rate: 2 << 20}
+	if w != want {
+		t.Errorf("ParseWindow() = %+v, want %+v", w, want)
+	}
+
+	for _, spec := range []string{"08:00-18:00", "08:00=1M", "8h-18h=1M", "08:00-24:00=1M", "08:00-18:00=1T"} {
+		if _, err := ParseWindow(spec); err == nil {
+			t.Errorf("ParseWindow(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	clock := func(hour, min int) time.Time {
+		return time.Date(2025, 3, 1, hour, min, 0, 0, time.UTC)
+	}
+	day := Window{Start: 8 * time.Hour, End: 18 * time.Hour}
+	night := Window{Start: 22 * time.Hour, End: 6 * time.Hour}
+
+	tests := []struct {
+		name string
+		w    Window
+		t    time.Time
+		want bool
+	}{
+		{"day start", day, clock(8, 0), true},
+		{"day middle", day, clock(12, 30), true},
+		{"day end", day, clock(18, 0), false},
+		{"day before", day, clock(7, 59), false},
+		{"night start", night, clock(22, 0), true},
+		{"night before midnight", night, clock(23, 59), true},
+		{"night at midnight", night, clock(0, 0), true},
+		{"night after midnight", night, clock(5, 59), true},
+		{"night end", night, clock(6, 0), false},
+		{"night during the day", night, clock(12, 0), false},
+		{"night before start", night, clock(21, 59), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.w.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%s) = %v, want %v", tt.t.Format("15:04"), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	if l := NewWithConfig(Config{}); l != nil {
+		t.Errorf("NewWithConfig() = %v, want nil without limits", l)
+	}
+	var none *Limiter
+	if got := none.Rate(time.Now()); got != 0 {
+		t.Errorf("nil Rate() = %d, want 0", got)
+	}
+
+	cfg, err := ParseConfig("1M", "", []string{"08:00-18:00=256K", "22:00-06:00=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewWithConfig(cfg)
+	tests := []struct {
+		hour int
+		want uint64
+	}{
+		{hour: 7, want: 1 << 20},
+		{hour: 12, want: 256 << 10},
+		{hour: 20, want: 1 << 20},
+		{hour: 23, want: 0},
+		{hour: 3, want: 0},
+	}
+	for _, tt := range tests {
+		at := time.Date(2025, 3, 1, tt.hour, 0, 0, 0, time.Local)
+		if got := l.Rate(at); got != tt.want {
+			t.Errorf("Rate(%02d:00) = %d, want %d", tt.hour, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package report records the checks and the updates of a service for the
+// updater agent and the CLI alike: in update_status.json, shown to the users,
+// in the update history and to the webhooks.
+package report
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/notify"
+	"github.com/sorayaormazabalmayo/general-service/internal/pin"
+	"github.com/sorayaormazabalmayo/general-service/internal/rollout"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+)
+
+// Reporter records the checks and the updates of Service on Host.
+type Reporter struct {
+	// StatusPath is the update_status.json and HistoryPath the update
+	// history written.
+	StatusPath  string
+	HistoryPath string
+	Service     string
+	Host        string
+	// TUF is the client whose metadata versions are recorded in the update
+	// history, none are when nil.
+	TUF *tufclient.Client
+	// Notifier notifies the webhooks, none are when nil.
+	Notifier *notify.Notifier
+}
+
+// For returns a copy of r reporting on service.
+func (r *Reporter) For(service string) *Reporter {
+	c := *r
+	c.Service = service
+	return &c
+}
+
+// Record appends e to the update history of the service. The updates go on if
+// it cannot be written.
+func (r *Reporter) Record(e history.Entry, log *slog.Logger) {
+	e.Service = r.Service
+	if r.TUF != nil {
+		if v := r.TUF.Versions(); v.Root != 0 {
+			e.Metadata = &v
+		}
+	}
+	if err := history.Append(r.HistoryPath, e); err != nil {
+		log.Error("Failed to record the update history", "event", e.Event, "error", err)
+	}
+}
+
+// Notify notifies the webhooks of e. The updates go on if it cannot be queued.
+func (r *Reporter) Notify(e notify.Event, log *slog.Logger) {
+	if r.Notifier == nil {
+		return
+	}
+	e.Service = r.Service
+	e.Host = r.Host
+	if err := r.Notifier.Notify(e); err != nil {
+		log.Error("Failed to notify the webhooks", "event", e.Type, "error", err)
+	}
+}
+
+// Channel returns the release channel selected from the UI or the CLI, or def.
+func (r *Reporter) Channel(def string) string {
+	s, err := status.Read(r.StatusPath)
+	if err != nil || channel.Validate(s.Channel) != nil {
+		return def
+	}
+	return s.Channel
+}
+
+// SetCheckError records why the last update check failed, or clears it when
+// checkErr is nil. It is kept apart from the error of the last update, which
+// holds back the update policy and the prefetch.
+func (r *Reporter) SetCheckError(checkErr error) error {
+	s, err := status.Read(r.StatusPath)
+	if err != nil {
+		return err
+	}
+	if s.CheckError == ErrorString(checkErr) {
+		return nil
+	}
+	return status.Update(r.StatusPath, func(s *status.Status) {
+		s.CheckError = ErrorString(checkErr)
+	})
+}
+
+// SetMetadataExpired records since when the trusted metadata is expired, or
+// clears it when the metadata is valid.
+func (r *Reporter) SetMetadataExpired(expires, now time.Time, log *slog.Logger) error {
+	s, err := status.Read(r.StatusPath)
+	if err != nil {
+		return err
+	}
+	expired := !expires.IsZero() && !now.Before(expires)
+	switch {
+	case expired && s.MetadataExpired != nil && s.MetadataExpired.Equal(expires):
+		return nil
+	case !expired && s.MetadataExpired == nil && s.ExpiredApproved == "":
+		return nil
+	case expired:
+		log.Warn("TUF metadata expired, nothing new is installed until it is refreshed", "expires", expires)
+	}
+	return status.Update(r.StatusPath, func(s *status.Status) {
+		if !expired {
+			s.MetadataExpired = nil
+			s.ExpiredApproved = ""
+			return
+		}
+		s.MetadataExpired = &expires
+	})
+}
+
+// Offer is an update of the service found by a check.
+type Offer struct {
+	Version string
+	Rollout *rollout.Rollout
+	// Pin and Blocklist are the configured ones. The pin and the blocklist
+	// selected in update_status.json override and extend them.
+	Pin       string
+	Blocklist []string
+	// Hold returns why the update is held back, if it is, given the status
+	// and the pin rules in force.
+	Hold func(status.Status, pin.Rules) error
+}
+
+// Offer offers the update o to the host unless it is held back or its staged
+// rollout has not reached the host yet. The history and the webhooks are told
+// about the updates that become available and the ones held back.
+func (r *Reporter) Offer(o Offer, now time.Time, log *slog.Logger) error {
+	s, err := status.Read(r.StatusPath)
+	if err != nil {
+		return err
+	}
+	rules, err := Rules(s, o.Pin, o.Blocklist)
+	if err != nil {
+		return err
+	}
+
+	log = log.With("available", o.Version)
+	heldBack := ""
+	pending := false
+	if err := o.Hold(s, rules); err != nil {
+		heldBack = err.Error()
+		log.Info("The update is held back", "reason", heldBack)
+	} else if !o.Rollout.Eligible(r.Host, o.Version, now) {
+		pending = true
+		log.Info("The update is being rolled out, this host will get it later", "rolloutPercent", o.Rollout.Current(now))
+	}
+
+	if heldBack == "" && !pending {
+		if s.UpdateAvailable == 1 && s.HeldBack == "" && !s.RolloutPending && s.ActivePin == rules.Pin.String() {
+			return nil
+		}
+		if err := status.Update(r.StatusPath, func(s *status.Status) {
+			s.UpdateAvailable = 1
+			s.UpdateRequested = 0
+			s.RequestSource = ""
+			s.RequestUser = ""
+			s.RequestTrace = ""
+			s.LastError = ""
+			s.Prefetched = ""
+		}); err != nil {
+			return err
+		}
+		log.Info("Update available")
+		if s.UpdateAvailable != 1 {
+			r.Record(history.Entry{Event: history.EventDetected, Version: o.Version, Outcome: history.OutcomeSuccess}, log)
+			r.Notify(notify.Event{Type: notify.EventUpdateAvailable, Version: o.Version}, log)
+		}
+	}
+	if heldBack != "" && heldBack != s.HeldBack {
+		r.Record(history.Entry{Event: history.EventDetected, Version: o.Version, Outcome: history.OutcomeHeldBack, Error: heldBack}, log)
+	}
+
+	return status.Update(r.StatusPath, func(s *status.Status) {
+		if heldBack != "" || pending {
+			s.UpdateAvailable = 0
+			s.UpdateRequested = 0
+			s.RequestSource = ""
+			s.RequestUser = ""
+			s.RequestTrace = ""
+		}
+		s.RolloutPending = pending
+		s.HeldBack = heldBack
+		s.ActivePin = rules.Pin.String()
+	})
+}
+
+// Rules returns the pin rules in force: the pin selected in s, or spec, and
+// blocklist extended with the versions blocklisted in s.
+func Rules(s status.Status, spec string, blocklist []string) (pin.Rules, error) {
+	if s.Pin != "" {
+		spec = s.Pin
+	}
+	return pin.NewRules(spec, append(append([]string{}, blocklist...), s.Blocklist...))
+}
+
+// IndexVersion returns the version of service in index, if it can be parsed.
+func IndexVersion(index []byte, service string) string {
+	var data map[string]struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(index, &data); err != nil {
+		return ""
+	}
+	return data[service].Version
+}
+
+// ErrorString returns the message of err, empty when nil.
+func ErrorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
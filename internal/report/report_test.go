@@ -0,0 +1,130 @@
+package report
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/pin"
+	"github.com/sorayaormazabalmayo/general-service/internal/rollout"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+)
+
+func newReporter(t *testing.T) *Reporter {
+	t.Helper()
+	dir := t.TempDir()
+	return &Reporter{
+		StatusPath:  filepath.Join(dir, "update_status.json"),
+		HistoryPath: filepath.Join(dir, "update_history.jsonl"),
+		Service:     "general-service",
+		Host:        "host-1",
+	}
+}
+
+func readStatus(t *testing.T, r *Reporter) status.Status {
+	t.Helper()
+	s, err := status.Read(r.StatusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func readHistory(t *testing.T, r *Reporter) []history.Entry {
+	t.Helper()
+	entries, err := history.Read(r.HistoryPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func hold(err error) func(status.Status, pin.Rules) error {
+	return func(status.Status, pin.Rules) error { return err }
+}
+
+func TestOffer(t *testing.T) {
+	r := newReporter(t)
+	now := time.Now()
+	o := Offer{Version: "v2025.03.01-sha.1a2b3c4", Pin: "v2025.03", Hold: hold(nil)}
+
+	// offered again on every check, recorded once
+	for range 2 {
+		if err := r.Offer(o, now, slog.Default()); err != nil {
+			t.Fatalf("Offer() error = %v", err)
+		}
+	}
+	s := readStatus(t, r)
+	if s.UpdateAvailable != 1 || s.HeldBack != "" || s.RolloutPending || s.ActivePin != "v2025.03" {
+		t.Errorf("status = %+v, want the update available with the pin active", s)
+	}
+	entries := readHistory(t, r)
+	if len(entries) != 1 || entries[0].Event != history.EventDetected || entries[0].Outcome != history.OutcomeSuccess || entries[0].Service != "general-service" {
+		t.Errorf("history = %+v, want one detected entry", entries)
+	}
+
+	// held back from then on
+	o.Hold = hold(errors.New("blocklisted"))
+	if err := r.Offer(o, now, slog.Default()); err != nil {
+		t.Fatalf("Offer() error = %v", err)
+	}
+	s = readStatus(t, r)
+	if s.UpdateAvailable != 0 || s.HeldBack != "blocklisted" {
+		t.Errorf("status = %+v, want the update held back", s)
+	}
+	if entries := readHistory(t, r); len(entries) != 2 || entries[1].Outcome != history.OutcomeHeldBack {
+		t.Errorf("history = %+v, want a held back entry", entries)
+	}
+}
+
+func TestOfferRolloutPending(t *testing.T) {
+	r := newReporter(t)
+	now := time.Now()
+	// a rollout that has not started reaches no host
+	o := Offer{Version: "v2025.03.01-sha.1a2b3c4", Rollout: &rollout.Rollout{Percentage: 100, Start: now.Add(time.Hour)}, Hold: hold(nil)}
+
+	if err := r.Offer(o, now, slog.Default()); err != nil {
+		t.Fatalf("Offer() error = %v", err)
+	}
+	if s := readStatus(t, r); s.UpdateAvailable != 0 || !s.RolloutPending {
+		t.Errorf("status = %+v, want the rollout pending", s)
+	}
+	if entries := readHistory(t, r); len(entries) != 0 {
+		t.Errorf("history = %+v, want nothing recorded", entries)
+	}
+}
+
+func TestRules(t *testing.T) {
+	s := status.Status{Pin: "v2025.04", Blocklist: []string{"v2025.03.02-sha.1a2b3c4"}}
+	rules, err := Rules(s, "v2025.03", []string{"v2025.03.01-sha.1a2b3c4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rules.Pin.String(); got != "v2025.04" {
+		t.Errorf("pin = %s, want the one selected in the status", got)
+	}
+	for _, v := range []string{"v2025.03.01-sha.1a2b3c4", "v2025.03.02-sha.1a2b3c4"} {
+		if rules.Check(v) == nil {
+			t.Errorf("%s is not blocklisted", v)
+		}
+	}
+}
+
+func TestSetCheckError(t *testing.T) {
+	r := newReporter(t)
+	if err := r.SetCheckError(errors.New("unreachable")); err != nil {
+		t.Fatal(err)
+	}
+	if s := readStatus(t, r); s.CheckError != "unreachable" {
+		t.Errorf("check error = %q, want unreachable", s.CheckError)
+	}
+	if err := r.SetCheckError(nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := readStatus(t, r); s.CheckError != "" {
+		t.Errorf("check error = %q, want it cleared", s.CheckError)
+	}
+}
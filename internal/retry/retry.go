@@ -0,0 +1,80 @@
+// Package retry retries operations with exponential backoff and jitter.
+package retry
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Policy describes how an operation is retried.
+type Policy struct {
+	// InitialInterval is the wait after the first failure. It is doubled
+	// after each failure, up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	// Jitter randomizes every wait by +/- this fraction so that a fleet of
+	// hosts does not retry in lockstep.
+	Jitter float64
+	// MaxAttempts is the maximum number of times the operation is run,
+	// including the first one. Zero means no limit.
+	MaxAttempts uint64
+}
+
+// DefaultPolicy is used when no policy is configured.
+var DefaultPolicy = Policy{
+	InitialInterval: 2 * time.Second,
+	MaxInterval:     time.Minute,
+	Jitter:          0.5,
+	MaxAttempts:     5,
+}
+
+// Notify is called after every failed attempt that is going to be retried,
+// with the error and the wait before the next attempt.
+type Notify func(err error, next time.Duration)
+
+// Do runs op until it succeeds, it fails with an error that retryable rejects,
+// the policy runs out of attempts or ctx is done. A nil retryable retries every
+// error. The last error is returned.
+func Do(ctx context.Context, p Policy, retryable func(error) bool, notify Notify, op func() error) error {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = p.InitialInterval
+	b.MaxInterval = p.MaxInterval
+	b.RandomizationFactor = p.Jitter
+	b.Multiplier = 2
+	// the number of attempts bounds the retries, not the elapsed time
+	b.MaxElapsedTime = 0
+
+	var bo backoff.BackOff = b
+	if p.MaxAttempts > 0 {
+		bo = backoff.WithMaxRetries(bo, p.MaxAttempts-1)
+	}
+	bo = backoff.WithContext(bo, ctx)
+
+	return backoff.RetryNotify(func() error {
+		err := op()
+		if err != nil && retryable != nil && !retryable(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, bo, backoff.Notify(notify))
+}
+
+// Backoff returns the wait after the given failed attempt, counted from 1,
+// for the operations retried across runs instead of with Do, e.g. the
+// deliveries of a persistent queue.
+func (p Policy) Backoff(attempt uint64) time.Duration {
+	d := p.InitialInterval
+	for i := uint64(1); i < attempt && d > 0 && (p.MaxInterval <= 0 || d < p.MaxInterval); i++ {
+		d *= 2
+	}
+	if p.MaxInterval > 0 && d > p.MaxInterval {
+		d = p.MaxInterval
+	}
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+	return d
+}
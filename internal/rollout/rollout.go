@@ -0,0 +1,99 @@
+// Package rollout decides whether a host takes part in the staged rollout of a
+// release. The decision is a deterministic function of the host ID and the
+// version, so hosts join the rollout progressively without a central server.
+package rollout
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Rollout is the optional rollout metadata of a service index:
+//
+//	"rollout": {
+//	  "percentage": 5,
+//	  "start": "2025-03-01T08:00:00Z",
+//	  "ramp": [{"after": "24h", "percentage": 25}, {"after": "72h", "percentage": 100}]
+//	}
+//
+// The release reaches Percentage of the hosts at Start, and each step of the
+// ramp raises it once its delay since Start has elapsed.
+type Rollout struct {
+	Percentage float64   `json:"percentage"`
+	Start      time.Time `json:"start,omitempty"`
+	Ramp       []Step    `json:"ramp,omitempty"`
+}
+
+// Step raises the percentage of the rollout After the start.
+type Step struct {
+	After      Duration `json:"after"`
+	Percentage float64  `json:"percentage"`
+}
+
+// Duration is a time.Duration encoded in JSON as a string such as "24h".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Validate returns an error if the rollout cannot be followed: the steps of a
+// ramp are relative to the start, which must be set.
+func (r *Rollout) Validate() error {
+	if r == nil {
+		return nil
+	}
+	if len(r.Ramp) > 0 && r.Start.IsZero() {
+		return errors.New("invalid rollout: the ramp has no start")
+	}
+	return nil
+}
+
+// Current returns the percentage of hosts the release reaches at now.
+func (r *Rollout) Current(now time.Time) float64 {
+	if r == nil {
+		return 100
+	}
+	if !r.Start.IsZero() && now.Before(r.Start) {
+		return 0
+	}
+
+	p := r.Percentage
+	for _, step := range r.Ramp {
+		if !now.Before(r.Start.Add(time.Duration(step.After))) && step.Percentage > p {
+			p = step.Percentage
+		}
+	}
+	return math.Min(math.Max(p, 0), 100)
+}
+
+// Eligible reports whether the host hostID gets version at now. A nil rollout
+// means that the release reaches every host.
+func (r *Rollout) Eligible(hostID, version string, now time.Time) bool {
+	return Bucket(hostID, version) < r.Current(now)
+}
+
+// Bucket places the host in [0, 100) for version. Hashing the version too
+// means that different hosts go first in different releases.
+func Bucket(hostID, version string) float64 {
+	sum := sha256.Sum256([]byte(hostID + "/" + version))
+	return float64(binary.BigEndian.Uint64(sum[:8])) / math.Pow(2, 64) * 100
+}
@@ -0,0 +1,114 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a cron-style time window with the usual five fields: minute, hour,
+// day of month, month and day of week (0 is Sunday). Each field accepts "*",
+// single values, ranges "a-b", lists "a,b" and steps "*/n" or "a-b/n". A time
+// is inside the window when every field matches it, e.g. "* 2-4 * * 1-5" is
+// from 02:00 to 04:59 on weekdays.
+type Window struct {
+	spec   string
+	fields [5]map[int]bool
+	// like cron, when both day fields are restricted a day matches if any of
+	// them does
+	domStar, dowStar bool
+}
+
+var fieldBounds = [5][2]int{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 6},  // day of week
+}
+
+// ParseWindow parses a cron-style window.
+func ParseWindow(spec string) (Window, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != 5 {
+		return Window{}, fmt.Errorf("invalid window %q: expected 5 fields, got %d", spec, len(parts))
+	}
+
+	w := Window{spec: spec, domStar: parts[2] == "*", dowStar: parts[4] == "*"}
+	for i, part := range parts {
+		values, err := parseField(part, fieldBounds[i][0], fieldBounds[i][1])
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid window %q: %w", spec, err)
+		}
+		w.fields[i] = values
+	}
+	return w, nil
+}
+
+// String returns the spec the window was parsed from.
+func (w Window) String() string {
+	return w.spec
+}
+
+// Contains reports whether t is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	if !w.fields[0][t.Minute()] || !w.fields[1][t.Hour()] || !w.fields[3][int(t.Month())] {
+		return false
+	}
+
+	dom := w.fields[2][t.Day()]
+	dow := w.fields[4][int(t.Weekday())]
+	if w.domStar || w.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func parseField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, item := range strings.Split(field, ",") {
+		rangePart, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			rangePart = item[:i]
+			step, err = strconv.Atoi(item[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", item)
+			}
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value in %q", item)
+			}
+			if hi, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("invalid value in %q", item)
+			}
+		default:
+			v, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in %q", item)
+			}
+			lo, hi = v, v
+			if strings.Contains(item, "/") {
+				// "a/n" means from a to the maximum every n
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", item, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
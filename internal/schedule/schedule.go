@@ -0,0 +1,118 @@
+// Package schedule decides when the updater checks for new updates. Checks are
+// spread with a random splay so that a fleet of hosts does not hit the TUF
+// repository in lockstep, and can be restricted to cron-style windows.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// maxWindowSearch bounds the search of the next time inside a window.
+const maxWindowSearch = 366 * 24 * time.Hour
+
+// Config holds the scheduling parameters.
+type Config struct {
+	// Interval is the base time between two checks.
+	Interval time.Duration
+	// Splay is the maximum random delay added to every interval.
+	Splay time.Duration
+	// Windows restricts the checks to the times, in the local time zone,
+	// inside any of them. When empty, checks can happen at any time.
+	Windows []string
+}
+
+// Scheduler tells the updater when to check for updates.
+type Scheduler struct {
+	interval time.Duration
+	splay    time.Duration
+	windows  []Window
+	trigger  chan struct{}
+}
+
+// New returns a scheduler for cfg.
+func New(cfg Config) (*Scheduler, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("invalid config: check interval must be positive")
+	}
+	if cfg.Splay < 0 {
+		return nil, fmt.Errorf("invalid config: check splay cannot be negative")
+	}
+
+	s := &Scheduler{
+		interval: cfg.Interval,
+		splay:    cfg.Splay,
+		trigger:  make(chan struct{}, 1),
+	}
+	for _, spec := range cfg.Windows {
+		w, err := ParseWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// Next returns the time of the check following one done at from.
+func (s *Scheduler) Next(from time.Time) time.Time {
+	next := from.Add(s.interval)
+	if s.splay > 0 {
+		next = next.Add(rand.N(s.splay))
+	}
+	return s.nextInWindow(next)
+}
+
+// Trigger asks for an immediate check, ignoring the windows. It never blocks;
+// triggers received while a check is pending are merged.
+func (s *Scheduler) Trigger() {
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Wait blocks until the check following one done at from is due or a check is
+// triggered, reporting which one happened. It returns ctx.Err() if ctx is done
+// first.
+func (s *Scheduler) Wait(ctx context.Context, from time.Time) (bool, error) {
+	timer := time.NewTimer(time.Until(s.Next(from)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return false, nil
+	case <-s.trigger:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// nextInWindow returns the first time not before t inside a window.
+func (s *Scheduler) nextInWindow(t time.Time) time.Time {
+	if len(s.windows) == 0 || s.inWindow(t) {
+		return t
+	}
+
+	// windows have a minute resolution
+	candidate := t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.Add(maxWindowSearch); candidate.Before(limit); candidate = candidate.Add(time.Minute) {
+		if s.inWindow(candidate) {
+			return candidate
+		}
+	}
+	// no window ever opens, do not stop checking altogether
+	return t
+}
+
+func (s *Scheduler) inWindow(t time.Time) bool {
+	for _, w := range s.windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// at returns a local time of March 2025, which starts on a Saturday.
+func at(day, hour, min int) time.Time {
+	return time.Date(2025, 3, day, hour, min, 0, 0, time.Local)
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		spec string
+		in   []time.Time
+		out  []time.Time
+	}{
+		{
+			spec: "* 2-4 * * 1-5",
+			in:   []time.Time{at(3, 2, 0), at(3, 4, 59)},
+			out:  []time.Time{at(3, 5, 0), at(3, 1, 59), at(1, 3, 0)},
+		},
+		{
+			spec: "* 22-23,0-5 * * *",
+			in:   []time.Time{at(1, 22, 0), at(1, 23, 59), at(2, 0, 0), at(2, 5, 59)},
+			out:  []time.Time{at(1, 21, 59), at(2, 6, 0), at(2, 12, 0)},
+		},
+		{
+			spec: "*/15 * * * *",
+			in:   []time.Time{at(1, 10, 0), at(1, 10, 45)},
+			out:  []time.Time{at(1, 10, 1), at(1, 10, 50)},
+		},
+		{
+			// both day fields restricted: the 15th or any Sunday
+			spec: "* * 15 * 0",
+			in:   []time.Time{at(15, 12, 0), at(2, 12, 0)},
+			out:  []time.Time{at(3, 12, 0)},
+		},
+		{
+			// one day field restricted: Sundays only
+			spec: "* * * * 0",
+			in:   []time.Time{at(2, 12, 0)},
+			out:  []time.Time{at(15, 12, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			w, err := ParseWindow(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseWindow() error = %v", err)
+			}
+			if w.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", w, tt.spec)
+			}
+			for _, tm := range tt.in {
+				if !w.Contains(tm) {
+					t.Errorf("Contains(%s) = false", tm.Format(time.DateTime))
+				}
+			}
+			for _, tm := range tt.out {
+				if w.Contains(tm) {
+					t.Errorf("Contains(%s) = true", tm.Format(time.DateTime))
+				}
+			}
+		})
+	}
+}
+
+func TestParseWindowInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 5-2 * * *", "* * 0 * *", "*/0 * * * *", "* * * * mon"} {
+		if _, err := ParseWindow(spec); err == nil {
+			t.Errorf("ParseWindow(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	s, err := New(Config{Interval: time.Hour, Windows: []string{"* 22-23,0-5 * * *"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		from, want time.Time
+	}{
+		// inside the window across midnight
+		{from: at(1, 23, 30), want: at(2, 0, 30)},
+		// the window opens in the evening
+		{from: at(1, 5, 30), want: at(1, 22, 0)},
+		{from: at(1, 12, 0), want: at(1, 22, 0)},
+	}
+	for _, tt := range tests {
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("Next(%s) = %s, want %s", tt.from.Format(time.DateTime), got.Format(time.DateTime), tt.want.Format(time.DateTime))
+		}
+	}
+}
+
+func TestNextSplay(t *testing.T) {
+	s, err := New(Config{Interval: time.Hour, Splay: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := at(1, 12, 0)
+	for range 100 {
+		next := s.Next(from)
+		if d := next.Sub(from); d < time.Hour || d >= time.Hour+10*time.Minute {
+			t.Fatalf("Next() = from + %s, want within the splay", d)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Interval: time.Hour, Splay: -time.Minute},
+		{Interval: time.Hour, Windows: []string{"* 25 * * *"}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded", cfg)
+		}
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	s, err := New(Config{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// triggers are merged and never block
+	s.Trigger()
+	s.Trigger()
+
+	triggered, err := s.Wait(context.Background(), time.Now())
+	if err != nil || !triggered {
+		t.Fatalf("Wait() = %v, %v, want triggered", triggered, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if triggered, err := s.Wait(ctx, time.Now()); err != context.Canceled || triggered {
+		t.Errorf("Wait() = %v, %v, want the context error", triggered, err)
+	}
+}
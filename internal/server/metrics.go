@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+)
+
+// Metrics served on the internal HTTP address, taken from update_status.json.
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metadataExpiredGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "general_service_tuf_metadata_expired",
+		Help: "1 while the trusted TUF metadata is expired and nothing new is installed.",
+	})
+	metadataExpiredSinceGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "general_service_tuf_metadata_expired_since_seconds",
+		Help: "Unix time at which the trusted TUF metadata expired, 0 while it is valid.",
+	})
+	updateAvailableGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "general_service_update_available",
+		Help: "1 while an update is available to install.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(metadataExpiredGauge, metadataExpiredSinceGauge, updateAvailableGauge)
+}
+
+// updateMetrics exports s.
+func updateMetrics(s status.Status) {
+	updateAvailableGauge.Set(float64(s.UpdateAvailable))
+	if s.MetadataExpired == nil {
+		metadataExpiredGauge.Set(0)
+		metadataExpiredSinceGauge.Set(0)
+		return
+	}
+	metadataExpiredGauge.Set(1)
+	metadataExpiredSinceGauge.Set(float64(s.MetadataExpired.Unix()))
+}
+
+// metricsHandler serves the metrics in the Prometheus format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
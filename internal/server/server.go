@@ -5,15 +5,21 @@ import (
 	"embed"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io/fs"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/saltosystems-internal/x/log"
 	pkgserver "github.com/saltosystems-internal/x/server"
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 //go:embed static/index.html
@@ -23,36 +29,30 @@ var staticFiles embed.FS
 
 type Server struct {
 	s      *pkgserver.GroupServer
-	logger log.Logger
+	logger *slog.Logger
 	cancel context.CancelFunc
 }
 
-type UpdateStatus struct {
-	UpdateAvailable int `json:"update_available"`
-	UpdateRequested int `json:"update_requested"`
-}
-
 var (
-	jsonFilePath = "/home/sormazabal/src/SALTO2/update_status.json"
-	updateStatus UpdateStatus
-	updateMutex  sync.Mutex
+	jsonFilePath    = status.DefaultPath
+	historyFilePath = history.DefaultPath
+	updateStatus    status.Status
+	updateMutex     sync.Mutex
 )
 
 // readUpdateStatus examinates that update_status.json exists and that can be poperly parsed
-func readUpdateStatus() {
+func readUpdateStatus(logger *slog.Logger) {
 	updateMutex.Lock()
 	defer updateMutex.Unlock()
 
-	file, err := os.ReadFile(jsonFilePath)
+	s, err := status.Read(jsonFilePath)
 	if err != nil {
-		fmt.Println("⚠️ Could not read update status file, using default (0)")
+		logger.Warn("Could not read update status file, using default (0)", "error", err)
 		return
 	}
 
-	err = json.Unmarshal(file, &updateStatus)
-	if err != nil {
-		fmt.Println("⚠️ Could not parse update status JSON, using default (0)")
-	}
+	updateStatus = s
+	updateMetrics(s)
 }
 
 // checkUpdateHandler is an HTTP hanfler function in GO that responds to an HTTP request with JSON data
@@ -62,22 +62,102 @@ func checkUpdateHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(updateStatus)
 }
 
-// runUpdaterHandler is an HTTP handler that initiated an update process when it retrieves a POST request
-func runUpdateHandler(w http.ResponseWriter, r *http.Request) {
+// runUpdaterHandler returns an HTTP handler that initiated an update process when it retrieves a POST request
+func runUpdateHandler(logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// the UI has no login, the requests are told apart by the address of the browser
+		user, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			user = r.RemoteAddr
+		}
+		logger.Info("Update requested from the UI", "user", user)
+		// the updater links the spans of the update to the span of this request
+		if err := setUpdateRequestedStatus(1, user, tracing.Inject(r.Context())); err != nil {
+			logger.Error("Could not request the update", "error", err)
+		}
+
+		// handleShutdown waits for a termination signal and shuts down the server
+		// syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+		// Restart the application (or notify an external service manager)
+	}
+}
+
+// checkNowHandler asks the updater to check for updates right away when it retrieves a POST request
+func checkNowHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	fmt.Println("⚙️ Running update process...")
-	setUpdateRequestedStatus(1)
+	if err := requestCheck(); err != nil {
+		http.Error(w, "Could not request the update check", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
 
-	// handleShutdown waits for a termination signal and shuts down the server
-	// syscall.Kill(syscall.Getpid(), syscall.SIGINT)
-	// Restart the application (or notify an external service manager)
+// channelHandler switches the release channel of the updater when it retrieves a POST request
+// with a {"channel": "<name>"} body
+func channelHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Channel string `json:"channel"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := channel.Validate(req.Channel); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := setChannel(req.Channel); err != nil {
+		http.Error(w, "Could not switch the channel", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
-func periodicUpdateCheck(ctx context.Context) {
+// historyHandler lists the update history, newest first. The limit query parameter caps the entries listed,
+// 50 by default, and event selects the entries of an event
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := history.Read(historyFilePath)
+	if err != nil {
+		http.Error(w, "Could not read the update history", http.StatusInternalServerError)
+		return
+	}
+	entries = history.Recent(history.Filter(entries, r.URL.Query().Get("event")), limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func periodicUpdateCheck(ctx context.Context, logger *slog.Logger) {
 
 	// A ticker is used to perform a specific action at a specific interval
 	// It repeatedly sends a signal on a channel ticker.C
@@ -88,12 +168,12 @@ func periodicUpdateCheck(ctx context.Context) {
 	for {
 		select {
 		case <-ticker.C:
-			readUpdateStatus()
+			readUpdateStatus(logger)
 			if updateStatus.UpdateAvailable == 1 {
-				fmt.Println("🔄 Update available! Notifying frontend.")
+				logger.Debug("Update available, notifying the frontend")
 			}
 		case <-ctx.Done():
-			fmt.Println("🛑 Stopping periodic update check...")
+			logger.Info("Stopping periodic update check")
 			return
 		}
 	}
@@ -113,22 +193,49 @@ func corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Setting "update_requested" to a value
-func setUpdateRequestedStatus(value int) error {
+// Setting "update_requested" to a value, recording that the UI user requested it and the traceparent of
+// the request
+func setUpdateRequestedStatus(value int, user, traceparent string) error {
 	updateMutex.Lock()
 	defer updateMutex.Unlock()
 
 	updateStatus.UpdateRequested = value
-	file, err := json.MarshalIndent(updateStatus, "", "  ")
-	if err != nil {
-		return err
-	}
+	return status.Update(jsonFilePath, func(s *status.Status) {
+		s.UpdateRequested = value
+		s.RequestSource = history.SourceUI
+		s.RequestUser = user
+		s.RequestTrace = traceparent
+	})
+}
 
-	return os.WriteFile(jsonFilePath, file, 0644)
+// requestCheck sets "check_requested" so that the updater checks for updates right away
+func requestCheck() error {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	updateStatus.CheckRequested = 1
+	return status.Update(jsonFilePath, func(s *status.Status) {
+		s.CheckRequested = 1
+	})
+}
+
+// setChannel selects the release channel and asks the updater to check it right away
+func setChannel(name string) error {
+	updateMutex.Lock()
+	defer updateMutex.Unlock()
+
+	updateStatus.Channel = name
+	updateStatus.CheckRequested = 1
+	return status.Update(jsonFilePath, func(s *status.Status) {
+		s.Channel = name
+		s.CheckRequested = 1
+	})
 }
 
 // NewServer brings up the server
-func NewServer(cfg *Config, logger log.Logger) (*Server, error) {
+func NewServer(cfg *Config, logger *slog.Logger) (*Server, error) {
+	logger = logger.With(logging.Phase, logging.PhaseServe)
+
 	var (
 		servers        []pkgserver.Server
 		httpServerOpts []pkgserver.HTTPServerOption
@@ -157,11 +264,19 @@ func NewServer(cfg *Config, logger log.Logger) (*Server, error) {
 	})
 
 	mux.HandleFunc("/check-update", checkUpdateHandler)
-	mux.HandleFunc("/run-update", runUpdateHandler)
+	mux.HandleFunc("/run-update", runUpdateHandler(logger))
+	mux.HandleFunc("/check-now", checkNowHandler)
+	mux.HandleFunc("/channel", channelHandler)
+	mux.HandleFunc("/api/v1/history", historyHandler)
 
-	wrappedMux := corsMiddleware(mux)
+	// every request gets a server span, named after its method and path
+	wrappedMux := otelhttp.NewHandler(corsMiddleware(mux), "general-service",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
 	ctx, cancel := context.WithCancel(context.Background())
-	go periodicUpdateCheck(ctx)
+	go periodicUpdateCheck(ctx, logger)
 
 	httpServerOpts = append(httpServerOpts, pkgserver.WithRoutes(
 		&pkgserver.Route{Pattern: "/", Handler: wrappedMux},
@@ -173,6 +288,18 @@ func NewServer(cfg *Config, logger log.Logger) (*Server, error) {
 	}
 	servers = append(servers, httpServer)
 
+	// the metrics are served on the internal address only
+	if cfg.InternatHTTPAddr != "" {
+		internalServer, err := pkgserver.NewHTTPServer(cfg.InternatHTTPAddr, pkgserver.WithRoutes(
+			&pkgserver.Route{Pattern: "/metrics", Handler: metricsHandler()},
+		))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		servers = append(servers, internalServer)
+	}
+
 	s, err := pkgserver.NewGroupServer(context.Background(), pkgserver.WithServers(servers))
 	if err != nil {
 		cancel()
@@ -184,14 +311,14 @@ func NewServer(cfg *Config, logger log.Logger) (*Server, error) {
 
 // It runs the server
 func (s *Server) Run() error {
-	fmt.Println("🚀 Server started...")
+	s.logger.Info("Server started")
 	return s.s.Run(context.Background())
 }
 
 // Shutdown shutdowns the server
 func (s *Server) Shutdown() {
-	fmt.Println("🛑 Shutting down server...")
+	s.logger.Info("Shutting down server")
 	s.cancel()
 	time.Sleep(1 * time.Second)
-	fmt.Println("✅ Server stopped.")
+	s.logger.Info("Server stopped")
 }
@@ -0,0 +1,140 @@
+// Package status reads and writes update_status.json, the file shared by the
+// updater and the server to exchange the state of the updates.
+package status
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// DefaultDir is the directory of the updater agent. It holds the status, the
+// trusted metadata in tmp/ and the targets in data/.
+const DefaultDir = "/home/sormazabal/src/SALTO2"
+
+// DefaultPath is where the server and the updater agent share the status.
+const DefaultPath = DefaultDir + "/update_status.json"
+
+// Status is the content of update_status.json.
+type Status struct {
+	UpdateAvailable int `json:"update_available"`
+	UpdateRequested int `json:"update_requested"`
+	// RequestSource and RequestUser tell who requested the pending update:
+	// the UI, the CLI or the update policy, see package history.
+	RequestSource string `json:"request_source,omitempty"`
+	RequestUser   string `json:"request_user,omitempty"`
+	// RequestTrace is the W3C traceparent of the HTTP request asking for the
+	// pending update, the spans of the update are linked to it.
+	RequestTrace string `json:"request_trace,omitempty"`
+	// CheckRequested asks the updater to check for updates right away.
+	CheckRequested int `json:"check_requested"`
+	// Channel is the release channel followed by the host. When empty, the
+	// channel configured in the updater is used.
+	Channel string `json:"channel,omitempty"`
+	// LastError describes why the last update attempt failed. It is cleared
+	// once an update is applied.
+	LastError string `json:"last_error,omitempty"`
+	// CheckError describes why the last check for updates failed. It is
+	// cleared by the next successful check.
+	CheckError string `json:"check_error,omitempty"`
+	// UpdatePolicy is the update policy of the service and NextAutoApply when
+	// the available update will be applied automatically, if ever.
+	UpdatePolicy  string     `json:"update_policy,omitempty"`
+	NextAutoApply *time.Time `json:"next_auto_apply,omitempty"`
+	// RolloutPending is set while a new update exists but its staged rollout
+	// has not reached this host yet.
+	RolloutPending bool `json:"rollout_pending,omitempty"`
+	// Pin and Blocklist are set from the UI or the CLI. Pin overrides the pin
+	// configured in the updater and Blocklist extends its blocklist.
+	Pin       string   `json:"pin,omitempty"`
+	Blocklist []string `json:"blocklist,omitempty"`
+	// ActivePin is the pin the updater is honoring and HeldBack why the
+	// latest release is not offered to this host, if it is not.
+	ActivePin string `json:"active_pin,omitempty"`
+	HeldBack  string `json:"held_back,omitempty"`
+	// Rollback allows the next update to install a version older than the
+	// installed one. It is cleared once an update is applied.
+	Rollback bool `json:"rollback,omitempty"`
+	// Prefetched is the version whose artifact has been downloaded and
+	// verified in the background, ready to install.
+	Prefetched string `json:"prefetched,omitempty"`
+	// MetadataExpired is when the trusted TUF metadata expired, while it
+	// cannot be refreshed. Nothing is installed meanwhile but ExpiredApproved,
+	// the version approved by an operator importing a bundle whose metadata
+	// has expired too.
+	MetadataExpired *time.Time `json:"metadata_expired,omitempty"`
+	ExpiredApproved string     `json:"expired_approved,omitempty"`
+}
+
+// Read returns the status stored in path. A missing file is not an error, the
+// zero status is returned instead.
+func Read(path string) (Status, error) {
+	var s Status
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// Update reads the status stored in path, applies fn to it and writes it back,
+// keeping the fields fn does not touch. The server and the updater update it
+// concurrently, so the update holds an exclusive lock on path+".lock".
+func Update(path string, fn func(*Status)) error {
+	// read-only is enough to lock it, whoever created it
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the status lock: %w", err)
+	}
+	defer lock.Close()
+	// released when the file is closed
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock the status: %w", err)
+	}
+
+	s, err := Read(path)
+	if err != nil {
+		return err
+	}
+
+	fn(&s)
+
+	return write(path, s)
+}
+
+// write replaces the status file atomically so that readers never see a
+// partially written file.
+func write(path string, s Status) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".update_status")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
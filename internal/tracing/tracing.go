@@ -0,0 +1,140 @@
+// Package tracing traces the update pipeline with OpenTelemetry: a span per
+// check, TUF refresh, index download, artifact download and verification,
+// extraction, activation, restart and health check, exported with OTLP.
+//
+// Tracing is disabled unless an endpoint is configured, the spans are then
+// dropped by the no-op provider installed by default.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of the spans below.
+const instrumentation = "github.com/sorayaormazabalmayo/general-service"
+
+// Spans of the update pipeline.
+const (
+	SpanCheck            = "check"
+	SpanRefresh          = "tuf.refresh"
+	SpanIndexDownload    = "index.download"
+	SpanUpdate           = "update"
+	SpanArtifactDownload = "artifact.download"
+	SpanVerify           = "artifact.verify"
+	SpanExtract          = "release.extract"
+	SpanActivate         = "release.activate"
+	SpanRestart          = "service.restart"
+	SpanHealthCheck      = "service.health_check"
+)
+
+// Attributes of the spans.
+const (
+	AttrService  = attribute.Key("general_service.service")
+	AttrVersion  = attribute.Key("general_service.version")
+	AttrBytes    = attribute.Key("general_service.bytes")
+	AttrUpdateID = attribute.Key("general_service.update_id")
+	AttrSource   = attribute.Key("general_service.source")
+	// AttrFrom is the version a delta was built from, AttrPeer set when the
+	// artifact is fetched from a peer and AttrUnit the systemd unit restarted.
+	AttrFrom = attribute.Key("general_service.from")
+	AttrPeer = attribute.Key("general_service.peer")
+	AttrUnit = attribute.Key("general_service.unit")
+)
+
+// Config holds the tracing configuration.
+type Config struct {
+	// Endpoint is the host:port of the OTLP gRPC collector. Tracing is
+	// disabled when empty.
+	Endpoint string
+	// Insecure connects to the collector without TLS.
+	Insecure bool
+}
+
+// Setup installs the tracer provider exporting the spans to the collector of
+// cfg. The returned function flushes and stops it.
+func Setup(ctx context.Context, cfg Config, version string) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+	}
+
+	provider := NewProvider(sdktrace.NewBatchSpanProcessor(exporter), version)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider sending the spans to processor, e.g.
+// a syncer of tracetest.NewInMemoryExporter in the tests.
+func NewProvider(processor sdktrace.SpanProcessor, version string) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName("general-service"),
+		semconv.ServiceVersion(version),
+	)
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithResource(res),
+	)
+}
+
+// Start starts a span of the update pipeline.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording err as its error status.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject returns the W3C traceparent of the span of ctx, to link the work it
+// requests to it, e.g. an update requested from the UI.
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Link returns the link to the span of the traceparent returned by Inject, if
+// it is valid.
+func Link(traceparent string) []trace.Link {
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []trace.Link{{SpanContext: sc}}
+}
+
+// StartLinked starts a root span of the update pipeline linked to the span of
+// traceparent, e.g. the HTTP request asking for the update.
+func StartLinked(ctx context.Context, name, traceparent string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name,
+		trace.WithNewRoot(),
+		trace.WithLinks(Link(traceparent)...),
+		trace.WithAttributes(attrs...),
+	)
+}
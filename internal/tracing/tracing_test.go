@@ -0,0 +1,92 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), "v2025.02.01-sha.0a1b2c3")
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func TestStartLinked(t *testing.T) {
+	recordSpans(t)
+
+	// the request of the update, e.g. from the UI
+	requestCtx, request := Start(context.Background(), "POST /update")
+	traceparent := Inject(requestCtx)
+	request.End()
+	if traceparent == "" {
+		t.Fatal("Inject() returned no traceparent")
+	}
+
+	// the update runs later in the agent, in a trace of its own
+	parentCtx, parent := Start(context.Background(), SpanCheck)
+	_, update := StartLinked(parentCtx, SpanUpdate, traceparent, AttrVersion.String("v2025.03.01-sha.1a2b3c4"))
+	update.End()
+	parent.End()
+
+	got := update.(sdktrace.ReadOnlySpan)
+	if got.Parent().IsValid() {
+		t.Errorf("update span has parent %s, want a root span", got.Parent().SpanID())
+	}
+	if got.SpanContext().TraceID() == request.SpanContext().TraceID() {
+		t.Error("update span in the trace of the request, want a new trace")
+	}
+	links := got.Links()
+	if len(links) != 1 {
+		t.Fatalf("update span has %d links, want 1", len(links))
+	}
+	if sc := links[0].SpanContext; sc.TraceID() != request.SpanContext().TraceID() || sc.SpanID() != request.SpanContext().SpanID() {
+		t.Errorf("update span linked to %s/%s, want the request span", sc.TraceID(), sc.SpanID())
+	}
+}
+
+func TestLinkInvalid(t *testing.T) {
+	for _, traceparent := range []string{"", "00-invalid", "00-00000000000000000000000000000000-0000000000000000-01"} {
+		if links := Link(traceparent); links != nil {
+			t.Errorf("Link(%q) = %v, want no link", traceparent, links)
+		}
+	}
+
+	recordSpans(t)
+	_, span := StartLinked(context.Background(), SpanUpdate, "00-invalid")
+	span.End()
+	if links := span.(sdktrace.ReadOnlySpan).Links(); len(links) != 0 {
+		t.Errorf("span linked to %v, want no link", links)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	exporter := recordSpans(t)
+	_, ok := Start(context.Background(), SpanVerify)
+	End(ok, nil)
+	_, failed := Start(context.Background(), SpanVerify)
+	End(failed, errors.New("hash mismatch"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans recorded, want 2", len(spans))
+	}
+	if spans[0].Status.Code != codes.Unset {
+		t.Errorf("status = %v, want unset", spans[0].Status.Code)
+	}
+	if spans[1].Status.Code != codes.Error || spans[1].Status.Description != "hash mismatch" || len(spans[1].Events) != 1 {
+		t.Errorf("status = %+v with %d events, want the error recorded", spans[1].Status, len(spans[1].Events))
+	}
+}
@@ -0,0 +1,353 @@
+// Package tufclient provides a long-lived TUF client that keeps the trusted
+// metadata in memory and throttles its refreshes: they are only done when the
+// refresh interval has elapsed or when the trusted timestamp or snapshot is
+// about to expire. The targets are looked up with the go-tuf updater of the
+// last refresh in between.
+//
+// A refresh first checks the remote timestamp against the trusted root. When
+// there is no new root and the timestamp did not change, the trusted metadata
+// is kept as is. Otherwise, as go-tuf allows a single refresh per updater, a
+// new one is built from the last trusted root and runs the full workflow.
+package tufclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/httpclient"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"github.com/theupdateframework/go-tuf/v2/metadata/config"
+	"github.com/theupdateframework/go-tuf/v2/metadata/fetcher"
+	"github.com/theupdateframework/go-tuf/v2/metadata/updater"
+)
+
+const (
+	defaultRefreshInterval = 60 * time.Second
+	defaultExpiryMargin    = 5 * time.Minute
+
+	// limits of the downloads checking whether the repository changed, the
+	// go-tuf defaults
+	rootMaxLength      = 512000
+	timestampMaxLength = 16384
+	downloadTimeout    = 15 * time.Second
+)
+
+// Config holds the parameters of the TUF client.
+type Config struct {
+	MetadataURL string
+	TargetsURL  string
+	// MetadataDir is where root.json lives and where go-tuf caches the
+	// trusted metadata.
+	MetadataDir string
+	// TargetsDir is where downloaded targets are cached.
+	TargetsDir string
+	// RefreshInterval is the maximum time between two refreshes.
+	RefreshInterval time.Duration
+	// ExpiryMargin forces a refresh this long before the trusted timestamp
+	// or snapshot expires.
+	ExpiryMargin time.Duration
+	// HTTPClient is used to fetch metadata and targets. When nil the go-tuf
+	// default fetcher is used.
+	HTTPClient *http.Client
+	// Fetcher, when set, is used to fetch metadata and targets instead of
+	// HTTPClient.
+	Fetcher fetcher.Fetcher
+	// AcceptExpired accepts metadata that expired at most this long ago. It
+	// is only meant for offline bundles imported with operator approval.
+	AcceptExpired time.Duration
+}
+
+// Versions holds the versions of the trusted top-level metadata.
+type Versions struct {
+	Root      int64 `json:"root"`
+	Timestamp int64 `json:"timestamp"`
+	Snapshot  int64 `json:"snapshot"`
+	Targets   int64 `json:"targets"`
+}
+
+// Client is a TUF client that can be shared by the whole agent.
+type Client struct {
+	cfg     Config
+	fetcher fetcher.Fetcher
+
+	mu          sync.Mutex
+	up          *updater.Updater
+	root        []byte
+	versions    Versions
+	expires     time.Time
+	lastRefresh time.Time
+}
+
+// New creates a client trusting the root.json found in cfg.MetadataDir. No
+// network access is done until the first refresh.
+func New(cfg Config) (*Client, error) {
+	if cfg.MetadataURL == "" {
+		return nil, fmt.Errorf("invalid config: metadata URL missing")
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = defaultRefreshInterval
+	}
+	if cfg.ExpiryMargin <= 0 {
+		cfg.ExpiryMargin = defaultExpiryMargin
+	}
+
+	rootBytes, err := os.ReadFile(filepath.Join(cfg.MetadataDir, "root.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted root: %w", err)
+	}
+
+	c := &Client{cfg: cfg, root: rootBytes}
+	switch {
+	case cfg.Fetcher != nil:
+		c.fetcher = cfg.Fetcher
+	case cfg.HTTPClient != nil:
+		c.fetcher = httpclient.NewFetcher(cfg.HTTPClient)
+	default:
+		c.fetcher = &fetcher.DefaultFetcher{}
+	}
+	return c, nil
+}
+
+// Refresh runs the TUF client workflow against the remote repository and
+// reports whether any of the top-level metadata changed. go-tuf only persists
+// metadata that is newer than the cached one. On error the previously trusted
+// metadata is kept.
+func (c *Client) Refresh() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.refresh()
+}
+
+// RefreshIfDue refreshes the metadata only when NextRefresh has been reached.
+func (c *Client) RefreshIfDue() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Now().Before(c.nextRefresh()) {
+		return false, nil
+	}
+	return c.refresh()
+}
+
+// NextRefresh returns when the metadata should be refreshed next.
+func (c *Client) NextRefresh() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.nextRefresh()
+}
+
+// Versions returns the versions of the trusted top-level metadata.
+func (c *Client) Versions() Versions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.versions
+}
+
+// TargetsDir returns the directory where the targets are cached.
+func (c *Client) TargetsDir() string {
+	return c.cfg.TargetsDir
+}
+
+// Expires returns when the trusted metadata expires, the earliest expiry of
+// the timestamp and the snapshot in MetadataDir. They are read from disk as
+// they may be updated by another client, e.g. one importing a bundle. It is
+// the zero time when there is no trusted timestamp yet.
+func (c *Client) Expires() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	timestamp, err := metadata.Timestamp().FromFile(filepath.Join(c.cfg.MetadataDir, metadata.TIMESTAMP+".json"))
+	if err != nil {
+		return c.expires
+	}
+	expires := timestamp.Signed.Expires
+	snapshot, err := metadata.Snapshot().FromFile(filepath.Join(c.cfg.MetadataDir, metadata.SNAPSHOT+".json"))
+	if err == nil && snapshot.Signed.Expires.Before(expires) {
+		expires = snapshot.Signed.Expires
+	}
+	return expires
+}
+
+// DownloadTarget returns the content of targetPath, stored at localPath. The
+// returned boolean is true when a valid copy was already cached locally.
+func (c *Client) DownloadTarget(targetPath, localPath string) ([]byte, bool, error) {
+	return c.DownloadDelegatedTarget(targetPath, localPath, "")
+}
+
+// DownloadDelegatedTarget works as DownloadTarget but, when role is not empty,
+// it first checks that targetPath is signed by that role. Nothing is written
+// to localPath if it is not.
+func (c *Client) DownloadDelegatedTarget(targetPath, localPath, role string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.up == nil {
+		if _, err := c.refresh(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	ti, err := c.up.GetTargetInfo(targetPath)
+	if err != nil {
+		return nil, false, classify(fmt.Errorf("getting info for target \"%s\": %w", targetPath, err))
+	}
+
+	if role != "" {
+		if err := c.verifyRole(ti, role); err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0750); err != nil {
+		return nil, false, err
+	}
+
+	path, tb, err := c.up.FindCachedTarget(ti, localPath)
+	if err != nil {
+		return nil, false, classify(fmt.Errorf("failed to find if there is a cached target: %w", err))
+	}
+	if path != "" {
+		return tb, true, nil
+	}
+
+	_, tb, err = c.up.DownloadTarget(ti, localPath, "")
+	if err != nil {
+		return nil, false, classify(fmt.Errorf("failed to download target %s: %w", targetPath, err))
+	}
+	return tb, false, nil
+}
+
+// verifyRole checks that the trusted metadata of role lists ti. The role is
+// trusted only if it was reached through the delegations while looking for ti.
+func (c *Client) verifyRole(ti *metadata.TargetFiles, role string) error {
+	trusted := c.up.GetTrustedMetadataSet()
+
+	md, ok := trusted.Targets[role]
+	if !ok {
+		return fmt.Errorf("%w: target %s is not delegated to role %s", ErrSignature, ti.Path, role)
+	}
+	signed, ok := md.Signed.Targets[ti.Path]
+	if !ok || !signed.Equal(*ti) {
+		return fmt.Errorf("%w: target %s is not signed by role %s", ErrSignature, ti.Path, role)
+	}
+	return nil
+}
+
+func (c *Client) nextRefresh() time.Time {
+	if c.up == nil {
+		return time.Time{}
+	}
+	next := c.lastRefresh.Add(c.cfg.RefreshInterval)
+	if byExpiry := c.expires.Add(-c.cfg.ExpiryMargin); byExpiry.Before(next) {
+		next = byExpiry
+	}
+	return next
+}
+
+// refresh keeps the trusted metadata when the repository did not change and
+// otherwise builds a new go-tuf updater, as go-tuf allows a single Refresh per
+// updater, and refreshes the metadata with it.
+func (c *Client) refresh() (bool, error) {
+	unchanged, err := c.unchanged()
+	if err != nil {
+		return false, classify(fmt.Errorf("failed to refresh trusted metadata: %w", err))
+	}
+	if unchanged {
+		c.lastRefresh = time.Now()
+		return false, nil
+	}
+
+	cfg, err := config.New(c.cfg.MetadataURL, c.root)
+	if err != nil {
+		return false, classify(err)
+	}
+	cfg.LocalMetadataDir = c.cfg.MetadataDir
+	cfg.LocalTargetsDir = c.cfg.TargetsDir
+	cfg.RemoteTargetsURL = c.cfg.TargetsURL
+	cfg.PrefixTargetsWithHash = true
+	cfg.Fetcher = c.fetcher
+
+	up, err := updater.New(cfg)
+	if err != nil {
+		return false, classify(fmt.Errorf("failed to create Updater instance: %w", err))
+	}
+	if c.cfg.AcceptExpired > 0 {
+		up.UnsafeSetRefTime(time.Now().Add(-c.cfg.AcceptExpired))
+	}
+	if err := up.Refresh(); err != nil {
+		return false, classify(fmt.Errorf("failed to refresh trusted metadata: %w", err))
+	}
+
+	trusted := up.GetTrustedMetadataSet()
+	root, err := trusted.Root.ToBytes(false)
+	if err != nil {
+		return false, classify(err)
+	}
+
+	versions := Versions{
+		Root:      trusted.Root.Signed.Version,
+		Timestamp: trusted.Timestamp.Signed.Version,
+		Snapshot:  trusted.Snapshot.Signed.Version,
+		Targets:   trusted.Targets[metadata.TARGETS].Signed.Version,
+	}
+	expires := trusted.Timestamp.Signed.Expires
+	if trusted.Snapshot.Signed.Expires.Before(expires) {
+		expires = trusted.Snapshot.Signed.Expires
+	}
+
+	changed := versions != c.versions
+	c.up = up
+	c.root = root
+	c.versions = versions
+	c.expires = expires
+	c.lastRefresh = time.Now()
+
+	return changed, nil
+}
+
+// unchanged reports whether the trusted metadata is still current: there is no
+// newer root and the remote timestamp, verified with the trusted root, has the
+// trusted version. It is false when nothing is trusted yet, when the metadata
+// is about to expire or when expired metadata is accepted, so the full workflow
+// runs. Errors are only returned for downloads that failed.
+func (c *Client) unchanged() (bool, error) {
+	if c.up == nil || c.cfg.AcceptExpired > 0 || !time.Now().Before(c.expires.Add(-c.cfg.ExpiryMargin)) {
+		return false, nil
+	}
+	trusted := c.up.GetTrustedMetadataSet()
+
+	base := strings.TrimSuffix(c.cfg.MetadataURL, "/")
+
+	nextRoot := fmt.Sprintf("%s/%d.%s.json", base, trusted.Root.Signed.Version+1, metadata.ROOT)
+	_, err := c.fetcher.DownloadFile(nextRoot, rootMaxLength, downloadTimeout)
+	var httpErr *metadata.ErrDownloadHTTP
+	switch {
+	case err == nil:
+		return false, nil
+	case !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound && httpErr.StatusCode != http.StatusForbidden:
+		return false, err
+	}
+
+	data, err := c.fetcher.DownloadFile(base+"/"+metadata.TIMESTAMP+".json", timestampMaxLength, downloadTimeout)
+	if err != nil {
+		return false, err
+	}
+	timestamp, err := metadata.Timestamp().FromBytes(data)
+	if err != nil {
+		return false, nil
+	}
+	if err := trusted.Root.VerifyDelegate(metadata.TIMESTAMP, timestamp); err != nil {
+		// the full workflow reports it
+		return false, nil
+	}
+	return timestamp.Signed.Version == trusted.Timestamp.Signed.Version, nil
+}
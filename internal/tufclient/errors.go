@@ -0,0 +1,69 @@
+package tufclient
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+)
+
+// Error kinds returned by the client. Every error coming out of a refresh or a
+// target lookup wraps exactly one of them, so callers can use errors.Is to
+// decide whether to retry or to raise an alarm.
+var (
+	// ErrExpiredMetadata means that the trusted metadata is expired and the
+	// repository did not provide a newer version.
+	ErrExpiredMetadata = errors.New("tuf metadata expired")
+	// ErrRollback means that the repository served a metadata version older
+	// than the one already trusted.
+	ErrRollback = errors.New("tuf rollback attack detected")
+	// ErrSignature means that metadata or a target failed signature, length or
+	// hash verification.
+	ErrSignature = errors.New("tuf verification failed")
+	// ErrNetwork means that the repository could not be reached or answered
+	// with an unexpected HTTP status.
+	ErrNetwork = errors.New("tuf network error")
+	// ErrUnknown is used for every other error.
+	ErrUnknown = errors.New("tuf error")
+)
+
+// classify wraps err with the error kind it belongs to.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	for _, kind := range []error{ErrExpiredMetadata, ErrRollback, ErrSignature, ErrNetwork, ErrUnknown} {
+		if errors.Is(err, kind) {
+			// already classified
+			return err
+		}
+	}
+
+	var (
+		urlErr *url.Error
+		netErr net.Error
+	)
+	switch {
+	case errors.Is(err, &metadata.ErrExpiredMetadata{}):
+		return fmt.Errorf("%w: %w", ErrExpiredMetadata, err)
+	case errors.Is(err, &metadata.ErrBadVersionNumber{}):
+		return fmt.Errorf("%w: %w", ErrRollback, err)
+	case errors.Is(err, &metadata.ErrUnsignedMetadata{}),
+		errors.Is(err, &metadata.ErrLengthOrHashMismatch{}):
+		return fmt.Errorf("%w: %w", ErrSignature, err)
+	case errors.Is(err, &metadata.ErrDownload{}),
+		errors.As(err, &urlErr),
+		errors.As(err, &netErr):
+		return fmt.Errorf("%w: %w", ErrNetwork, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrUnknown, err)
+	}
+}
+
+// Retryable reports whether an operation that failed with err is worth
+// retrying. Only network errors are; any other kind needs an operator.
+func Retryable(err error) bool {
+	return errors.Is(err, ErrNetwork)
+}
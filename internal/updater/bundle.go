@@ -0,0 +1,160 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/bundle"
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/hostid"
+	"github.com/sorayaormazabalmayo/general-service/internal/index"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/report"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+)
+
+// ImportBundle applies an offline bundle created with export-bundle. The metadata is verified with
+// the trusted root.json exactly as an online check does, and the updates of the bundle are offered
+// as usual. The artifact of an offered update is left ready to install, as if it had been prefetched.
+//
+// The bundle is imported into agentDir, the directory of the updater agent, see status.DefaultDir: its
+// trusted metadata in tmp/, its targets in data/ and its status are the ones updated.
+//
+// acceptExpired is the operator approval to import a bundle whose metadata expired at most that long
+// ago. The update it offers is then the only one installed until the metadata is valid again.
+func ImportBundle(cfg Config, agentDir, path string, acceptExpired time.Duration) error {
+	metadataDir := filepath.Join(agentDir, "tmp")
+	if info, err := os.Stat(metadataDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("no metadata directory of the agent in %s, is the agent installed there?", agentDir)
+	}
+	if _, err := os.Stat(filepath.Join(metadataDir, "root.json")); err != nil {
+		return fmt.Errorf("no trusted root, it must be installed before importing a bundle: %w", err)
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = channel.Stable
+	}
+	roles, err := channel.ParseRoles(cfg.ChannelRoles)
+	if err != nil {
+		return err
+	}
+	hostID, err := hostid.Load(filepath.Join(agentDir, "host-id"))
+	if err != nil {
+		return err
+	}
+
+	b, err := bundle.Open(path)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	client, err := tufclient.New(tufclient.Config{
+		MetadataURL:   bundle.MetadataURL,
+		TargetsURL:    bundle.TargetsURL,
+		MetadataDir:   metadataDir,
+		TargetsDir:    filepath.Join(agentDir, "data"),
+		Fetcher:       b.Fetcher(),
+		AcceptExpired: acceptExpired,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := client.Refresh(); err != nil {
+		return fmt.Errorf("the bundle failed verification: %w", err)
+	}
+	reporter := &report.Reporter{
+		StatusPath:  filepath.Join(agentDir, "update_status.json"),
+		HistoryPath: filepath.Join(agentDir, "update_history.jsonl"),
+		Host:        hostID,
+		TUF:         client,
+	}
+	log := slog.With(logging.Phase, logging.PhaseBundle)
+	expires := client.Expires()
+	if err := reporter.SetMetadataExpired(expires, time.Now(), log); err != nil {
+		return err
+	}
+	approve := !time.Now().Before(expires)
+
+	releaseChannel := reporter.Channel(cfg.Channel)
+	for _, service := range services {
+		r := reporter.For(service)
+		indexData, _, err := DownloadTargetIndex(client, service, releaseChannel, roles.For(releaseChannel))
+		if err != nil {
+			return err
+		}
+		if err := offerUpdate(r, cfg, indexData, time.Now()); err != nil {
+			return err
+		}
+		if err := stageArtifact(b, r, cfg, indexData, metadataDir, approve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageArtifact copies the artifact of the offered update of the service of r from the bundle to dir,
+// where the updater picks up the prefetched artifacts, and records it in update_status.json. approve
+// allows installing it although the metadata has expired.
+func stageArtifact(b *bundle.Bundle, r *report.Reporter, cfg Config, indexData []byte, dir string, approve bool) error {
+	service := r.Service
+	log := slog.With(logging.Service, service, logging.Phase, logging.PhaseBundle)
+	s, err := status.Read(r.StatusPath)
+	if err != nil {
+		return err
+	}
+	if s.UpdateAvailable != 1 {
+		log.Info("No update to install from the bundle", "reason", s.HeldBack)
+		return nil
+	}
+
+	var data map[string]struct {
+		Path      string           `json:"path"`
+		Bytes     string           `json:"bytes"`
+		Hashes    index.Hashes     `json:"hashes"`
+		Artifacts []index.Artifact `json:"artifacts,omitempty"`
+		Version   string           `json:"version"`
+	}
+	if err := json.Unmarshal(indexData, &data); err != nil {
+		return fmt.Errorf("error parsing the index: %w", err)
+	}
+	info := data[service]
+
+	artifact := index.Artifact{Path: info.Path, Bytes: info.Bytes, Hashes: info.Hashes}
+	if len(info.Artifacts) > 0 {
+		if artifact, err = index.Select(info.Artifacts, runtime.GOOS, runtime.GOARCH, cfg.ArtifactVariant); err != nil {
+			return err
+		}
+	}
+	length, err := artifact.Length()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = b.CopyArtifact(artifact.Hashes.Sha256, length, filepath.Join(dir, fmt.Sprintf("%s.zip", service)))
+	r.Record(history.Entry{
+		Event:      history.EventDownload,
+		Version:    info.Version,
+		Source:     history.SourceBundle,
+		Outcome:    history.Outcome(err),
+		Error:      report.ErrorString(err),
+		DurationMS: history.Since(start),
+	}, log)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Update ready to install", "available", info.Version)
+	return status.Update(r.StatusPath, func(s *status.Status) {
+		s.Prefetched = info.Version
+		if approve {
+			s.ExpiredApproved = info.Version
+		}
+	})
+}
@@ -0,0 +1,271 @@
+package updater
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/retry"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+	"github.com/theupdateframework/go-tuf/v2/metadata"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testIndex = `{"general-service": {"version": "v2025.03.01-sha.1a2b3c4", "path": "https://example.com/general-service.zip", "bytes": "1024", "hashes": {"sha256": "00"}}}`
+
+// repository serves a TUF repository whose top-level roles are all signed by
+// the same key and whose targets are files.
+type repository struct {
+	server *httptest.Server
+	root   []byte
+	// failing makes the repository answer every request with an error
+	failing atomic.Bool
+}
+
+func newRepository(t *testing.T, files map[string][]byte) *repository {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := signature.LoadSigner(priv, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := metadata.KeyFromPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(24 * time.Hour)
+	root := metadata.Root(expires)
+	root.Signed.ConsistentSnapshot = false
+	for _, role := range []string{metadata.ROOT, metadata.TARGETS, metadata.SNAPSHOT, metadata.TIMESTAMP} {
+		if err := root.Signed.AddKey(key, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	targets := metadata.Targets(expires)
+	for name, data := range files {
+		if targets.Signed.Targets[name], err = metadata.TargetFile().FromBytes(name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snapshot := metadata.Snapshot(expires)
+	timestamp := metadata.Timestamp(expires)
+
+	served := map[string][]byte{}
+	for name, data := range files {
+		served["/targets/"+name] = data
+	}
+	sign := func(name string, md interface {
+		Sign(signature.Signer) (*metadata.Signature, error)
+		ToBytes(bool) ([]byte, error)
+	}) {
+		if _, err := md.Sign(signer); err != nil {
+			t.Fatal(err)
+		}
+		data, err := md.ToBytes(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		served["/metadata/"+name+".json"] = data
+	}
+	sign(metadata.ROOT, root)
+	sign(metadata.TARGETS, targets)
+	sign(metadata.SNAPSHOT, snapshot)
+	sign(metadata.TIMESTAMP, timestamp)
+
+	r := &repository{root: served["/metadata/root.json"]}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, ok := served[req.URL.Path]
+		switch {
+		case r.failing.Load():
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case !ok:
+			http.NotFound(w, req)
+		default:
+			w.Write(data)
+		}
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// newTestClient returns a TUF client of the repository trusting its root, and
+// moves to a temporary working directory where the targets are downloaded.
+func newTestClient(t *testing.T, r *repository) *tufclient.Client {
+	t.Helper()
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	metadataDir := filepath.Join(dir, "tmp")
+	if err := os.Mkdir(metadataDir, 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(metadataDir, "root.json"), r.root, 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err := tufclient.New(tufclient.Config{
+		MetadataURL: r.server.URL + "/metadata",
+		TargetsURL:  r.server.URL + "/targets",
+		MetadataDir: metadataDir,
+		TargetsDir:  filepath.Join(dir, "data"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// recordSpans installs a tracer provider recording the spans in memory.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), "v2025.02.01-sha.0a1b2c3")
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func spanNamed(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, s := range spans {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no %s span in %d spans", name, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func attr(s tracetest.SpanStub, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestCheckServiceTraces(t *testing.T) {
+	r := newRepository(t, map[string][]byte{channel.IndexPath("general-service", channel.Stable): []byte(testIndex)})
+	client := newTestClient(t, r)
+	exporter := recordSpans(t)
+
+	index, _, err := checkService(client, client.Refresh, "general-service", channel.Stable, "", history.SourceRequest, retry.Policy{MaxAttempts: 1}, nil)
+	if err != nil {
+		t.Fatalf("checkService() error = %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	check := spanNamed(t, spans, tracing.SpanCheck)
+	refresh := spanNamed(t, spans, tracing.SpanRefresh)
+	download := spanNamed(t, spans, tracing.SpanIndexDownload)
+
+	if check.Parent.IsValid() {
+		t.Errorf("check span has parent %s, want none", check.Parent.SpanID())
+	}
+	for _, child := range []tracetest.SpanStub{refresh, download} {
+		if child.Parent.SpanID() != check.SpanContext.SpanID() || child.SpanContext.TraceID() != check.SpanContext.TraceID() {
+			t.Errorf("%s span is not a child of the check span", child.Name)
+		}
+	}
+	if got := attr(check, tracing.AttrVersion).AsString(); got != "v2025.03.01-sha.1a2b3c4" {
+		t.Errorf("check version = %q, want v2025.03.01-sha.1a2b3c4", got)
+	}
+	if got := attr(check, tracing.AttrService).AsString(); got != "general-service" {
+		t.Errorf("check service = %q, want general-service", got)
+	}
+	if got := attr(download, tracing.AttrBytes).AsInt64(); got != int64(len(index)) || got != int64(len(testIndex)) {
+		t.Errorf("index download bytes = %d, want %d", got, len(testIndex))
+	}
+	for _, s := range spans {
+		if s.Status.Code == codes.Error {
+			t.Errorf("%s span has error status %q", s.Name, s.Status.Description)
+		}
+	}
+	if got := check.Resource.String(); !strings.Contains(got, "v2025.02.01-sha.0a1b2c3") {
+		t.Errorf("resource %s has no service version", got)
+	}
+}
+
+func TestCheckServiceTracesErrors(t *testing.T) {
+	r := newRepository(t, map[string][]byte{channel.IndexPath("general-service", channel.Stable): []byte(testIndex)})
+	client := newTestClient(t, r)
+	exporter := recordSpans(t)
+
+	t.Run("index missing", func(t *testing.T) {
+		exporter.Reset()
+		_, _, err := checkService(client, client.Refresh, "missing-service", channel.Stable, "", history.SourceSchedule, retry.Policy{MaxAttempts: 1}, nil)
+		if err == nil {
+			t.Fatal("checkService() succeeded for a service without index")
+		}
+		spans := exporter.GetSpans()
+		check := spanNamed(t, spans, tracing.SpanCheck)
+		download := spanNamed(t, spans, tracing.SpanIndexDownload)
+		if refresh := spanNamed(t, spans, tracing.SpanRefresh); refresh.Status.Code == codes.Error {
+			t.Errorf("refresh span has error status %q", refresh.Status.Description)
+		}
+		for _, s := range []tracetest.SpanStub{check, download} {
+			if s.Status.Code != codes.Error || s.Status.Description != err.Error() {
+				t.Errorf("%s span status = %v %q, want error %q", s.Name, s.Status.Code, s.Status.Description, err)
+			}
+		}
+		if len(download.Events) == 0 || download.Events[0].Name != "exception" {
+			t.Errorf("index download span did not record the error")
+		}
+		if attr(check, tracing.AttrVersion).AsString() != "" {
+			t.Errorf("check span has a version without index")
+		}
+	})
+
+	t.Run("refresh failing", func(t *testing.T) {
+		exporter.Reset()
+		r.failing.Store(true)
+		defer r.failing.Store(false)
+		_, _, err := checkService(client, client.Refresh, "general-service", channel.Stable, "", history.SourceSchedule, retry.Policy{MaxAttempts: 1}, nil)
+		if err == nil {
+			t.Fatal("checkService() succeeded with the repository unavailable")
+		}
+		spans := exporter.GetSpans()
+		if len(spans) != 2 {
+			t.Fatalf("got %d spans, want the check and the refresh", len(spans))
+		}
+		for _, name := range []string{tracing.SpanCheck, tracing.SpanRefresh} {
+			if s := spanNamed(t, spans, name); s.Status.Code != codes.Error {
+				t.Errorf("%s span status = %v, want error", name, s.Status.Code)
+			}
+		}
+	})
+}
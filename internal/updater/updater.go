@@ -1,80 +1,321 @@
 package updater
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 
-	stdlog "log"
-
-	"github.com/go-logr/stdr"
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/hostid"
+	"github.com/sorayaormazabalmayo/general-service/internal/httpclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/index"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/notify"
+	"github.com/sorayaormazabalmayo/general-service/internal/pin"
+	"github.com/sorayaormazabalmayo/general-service/internal/policy"
+	"github.com/sorayaormazabalmayo/general-service/internal/ratelimit"
+	"github.com/sorayaormazabalmayo/general-service/internal/report"
+	"github.com/sorayaormazabalmayo/general-service/internal/retry"
+	"github.com/sorayaormazabalmayo/general-service/internal/rollout"
+	"github.com/sorayaormazabalmayo/general-service/internal/schedule"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/version"
 	"github.com/theupdateframework/go-tuf/v2/metadata"
-	"github.com/theupdateframework/go-tuf/v2/metadata/config"
-	"github.com/theupdateframework/go-tuf/v2/metadata/updater"
+)
+
+// DefaultMetadataURL and DefaultTargetsURL locate the TUF repository used
+// unless the updater is pointed at a mirror.
+const (
+	DefaultMetadataURL = "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/metadata"
+	DefaultTargetsURL  = "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/targets"
 )
 
 const (
-	metadataURL          = "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/metadata"
-	targetsURL           = "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/targets"
-	verbosity            = 0
 	generateRandomFolder = false
 )
 
 var (
-	services   = []string{"general-service"}
-	jsonPath   = "update_status.json"
-	checkDelay = 60 * time.Second
+	services    = []string{"general-service"}
+	jsonPath    = "update_status.json"
+	historyPath = "update_history.jsonl"
+	hostIDPath  = "host-id"
+	outboxPath  = "outbox"
 )
 
-type UpdateStatus struct {
-	UpdateAvailable int `json:"update_available"`
+// statusPollDelay is how often update_status.json is read looking for check requests.
+const statusPollDelay = 5 * time.Second
+
+// Config holds the updater configuration.
+type Config struct {
+	// Version is the running version, reported in the User-Agent.
+	Version string
+	// HTTP configures the transport used for TUF and artifact downloads.
+	HTTP httpclient.Config
+	// MetadataURL and TargetsURL locate the TUF repository, or a mirror of
+	// it. The trusted root is the same either way.
+	MetadataURL string
+	TargetsURL  string
+	// Retry is the retry policy of every update check.
+	Retry retry.Policy
+	// Schedule decides when the checks are done.
+	Schedule schedule.Config
+	// RefreshInterval is the maximum time between two refreshes of the TUF
+	// metadata by scheduled checks. Requested checks always refresh.
+	RefreshInterval time.Duration
+	// Policies are the update policy specs, see policy.ParseSet.
+	Policies []string
+	// Channel is the release channel followed unless another one is selected
+	// in update_status.json, and ChannelRoles the "<channel>=<role>" roles
+	// expected to sign each channel.
+	Channel      string
+	ChannelRoles []string
+	// Pin is the version or range the services are held at and Blocklist the
+	// versions that are never offered, see package pin.
+	Pin       string
+	Blocklist []string
+	// ArtifactVariant is the variant of the artifacts preferred for this host.
+	ArtifactVariant string
+	// DownloadRate, DownloadBurst and DownloadRateWindows limit the download
+	// rate, see ratelimit.ParseConfig.
+	DownloadRate        string
+	DownloadBurst       string
+	DownloadRateWindows []string
+	// WebhookURLs are notified of the updates available, with the calls
+	// signed with the secret of WebhookSecretFile, see package notify.
+	WebhookURLs       []string
+	WebhookSecretFile string
 }
 
 // Run executes the updater logic.
-func Run() error {
+func Run(cfg Config) error {
 	// Set up logging.
-	metadata.SetLogger(stdr.New(stdlog.New(os.Stdout, "updater: ", stdlog.LstdFlags)))
-	stdr.SetVerbosity(verbosity)
-	log := metadata.GetLogger()
+	metadata.SetLogger(logging.TUF(slog.Default()))
+	log := slog.Default().With(logging.Phase, logging.PhaseCheck)
 
 	metadataDir, err := InitEnvironment()
 	if err != nil {
-		log.Error(err, "Failed to initialize environment")
+		log.Error("Failed to initialize environment", "error", err)
+		return err
+	}
+
+	if cfg.MetadataURL == "" {
+		cfg.MetadataURL = DefaultMetadataURL
+	}
+	if cfg.TargetsURL == "" {
+		cfg.TargetsURL = DefaultTargetsURL
+	}
+
+	rateLimit, err := ratelimit.ParseConfig(cfg.DownloadRate, cfg.DownloadBurst, cfg.DownloadRateWindows)
+	if err != nil {
+		log.Error("Invalid download rate limit", "error", err)
+		return err
+	}
+	cfg.HTTP.Limiter = ratelimit.NewWithConfig(rateLimit)
+
+	if cfg.HTTP.UserAgent == "" {
+		cfg.HTTP.UserAgent = httpclient.UserAgent(cfg.Version)
+	}
+	httpClient, err := httpclient.New(cfg.HTTP)
+	if err != nil {
+		log.Error("Failed to create HTTP client", "error", err)
+		return err
+	}
+
+	if cfg.Channel == "" {
+		cfg.Channel = channel.Stable
+	}
+	if err := channel.Validate(cfg.Channel); err != nil {
+		return err
+	}
+	roles, err := channel.ParseRoles(cfg.ChannelRoles)
+	if err != nil {
+		return err
+	}
+
+	if _, err := pin.NewRules(cfg.Pin, cfg.Blocklist); err != nil {
+		log.Error("Invalid version pin", "error", err)
+		return err
+	}
+
+	policies, err := policy.ParseSet(cfg.Policies)
+	if err != nil {
+		log.Error("Invalid update policy", "error", err)
+		return err
+	}
+
+	scheduler, err := schedule.New(cfg.Schedule)
+	if err != nil {
+		log.Error("Failed to create check scheduler", "error", err)
+		return err
+	}
+
+	if err = InitTrustOnFirstUse(httpClient, cfg.MetadataURL, metadataDir); err != nil {
+		log.Error("Trust-On-First-Use failed", "error", err)
 		return err
 	}
 
-	if err = InitTrustOnFirstUse(metadataDir); err != nil {
-		log.Error(err, "Trust-On-First-Use failed")
+	hostID, err := hostid.Load(hostIDPath)
+	if err != nil {
+		log.Error("Failed to load host ID", "error", err)
+		return err
+	}
+	reporter := &report.Reporter{StatusPath: jsonPath, HistoryPath: historyPath, Host: hostID}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	if len(cfg.WebhookURLs) > 0 {
+		secret, err := notify.ReadSecret(cfg.WebhookSecretFile)
+		if err != nil {
+			log.Error("Failed to read the webhook secret", "error", err)
+			return err
+		}
+		reporter.Notifier, err = notify.New(notify.Config{URLs: cfg.WebhookURLs, Secret: secret, Outbox: filepath.Join(cwd, outboxPath)})
+		if err != nil {
+			log.Error("Failed to create the notifier", "error", err)
+			return err
+		}
+		go reporter.Notifier.Run(context.Background(), slog.Default().With(logging.Phase, logging.PhaseNotify))
+	}
+
+	client, err := tufclient.New(tufclient.Config{
+		MetadataURL:     cfg.MetadataURL,
+		TargetsURL:      cfg.TargetsURL,
+		MetadataDir:     metadataDir,
+		TargetsDir:      filepath.Join(cwd, "data"),
+		RefreshInterval: cfg.RefreshInterval,
+		HTTPClient:      httpClient,
+	})
+	if err != nil {
+		log.Error("Failed to create TUF client", "error", err)
 		return err
 	}
+	reporter.TUF = client
 
-	// Check for updates in a loop.
+	if cfg.Retry.MaxAttempts == 0 {
+		cfg.Retry = retry.DefaultPolicy
+	}
+	notify := func(err error, next time.Duration) {
+		log.Warn("Update check failed", "error", err, "retryIn", next)
+	}
+
+	go watchCheckRequests(context.Background(), scheduler)
+
+	// Check for updates in a loop. A triggered check refreshes the metadata
+	// even if it is not due yet.
+	triggered := false
 	for {
+		checkStart := time.Now()
+		refresh := client.RefreshIfDue
+		if triggered {
+			refresh = client.Refresh
+		}
+		releaseChannel := reporter.Channel(cfg.Channel)
+		source := history.SourceSchedule
+		if triggered {
+			source = history.SourceRequest
+		}
 		for _, service := range services {
-			_, found, err := DownloadTargetIndex(metadataDir, service)
+			r := reporter.For(service)
+			index, found, err := checkService(client, refresh, service, releaseChannel, roles.For(releaseChannel), source, cfg.Retry, notify)
+			r.Record(history.Entry{
+				Event:      history.EventCheck,
+				Version:    report.IndexVersion(index, service),
+				Source:     source,
+				Outcome:    history.Outcome(err),
+				Error:      report.ErrorString(err),
+				DurationMS: history.Since(checkStart),
+			}, log)
 			if err != nil {
-				log.Error(err, "Failed to download target index")
+				log.Error("Failed to download target index", logging.Service, service, "error", err, "retryable", tufclient.Retryable(err))
+				if err := r.SetCheckError(err); err != nil {
+					log.Error("Failed to update update_status.json", "error", err)
+				}
+				continue
 			}
-			if found == 0 {
-				if err := setUpdateStatus(1); err != nil {
-					fmt.Println("Error updating update_status.json:", err)
-				} else {
-					fmt.Println("Update available flag set in update_status.json")
+			if err := r.SetCheckError(nil); err != nil {
+				log.Error("Failed to update update_status.json", "error", err)
+			}
+			// an update already offered or held back is evaluated again on every check
+			if found == 0 || updatePending() {
+				if err := offerUpdate(r, cfg, index, time.Now()); err != nil {
+					log.Error("Failed to update update_status.json", logging.Service, service, "error", err)
 				}
 			} else {
-				fmt.Println("Local index is up-to-date.")
+				log.Info("Local index is up-to-date", logging.Service, service)
+			}
+			if err := applyUpdatePolicy(r, policies.For(service), index, time.Now()); err != nil {
+				log.Error("Failed to evaluate update policy", logging.Service, service, "error", err)
+			}
+		}
+		// past its expiry the metadata cannot be trusted and nothing new is installed until it is refreshed,
+		// or until an operator imports a bundle
+		if err := reporter.SetMetadataExpired(client.Expires(), time.Now(), slog.With(logging.Phase, logging.PhaseTUF)); err != nil {
+			log.Error("Failed to update update_status.json", "error", err)
+		}
+
+		triggered, _ = scheduler.Wait(context.Background(), checkStart)
+	}
+}
+
+// checkService refreshes the trusted metadata with refresh and downloads the index of service in
+// releaseChannel, signed by role, retrying with policy. The check is traced, the refresh and the
+// download being its children.
+func checkService(client *tufclient.Client, refresh func() (bool, error), service, releaseChannel, role, source string, policy retry.Policy, notify retry.Notify) (index []byte, found int, err error) {
+	ctx, span := tracing.Start(context.Background(), tracing.SpanCheck, tracing.AttrService.String(service), tracing.AttrSource.String(source))
+	err = retry.Do(ctx, policy, tufclient.Retryable, notify, func() error {
+		_, refreshSpan := tracing.Start(ctx, tracing.SpanRefresh)
+		_, err := refresh()
+		tracing.End(refreshSpan, err)
+		if err != nil {
+			return err
+		}
+		_, indexSpan := tracing.Start(ctx, tracing.SpanIndexDownload)
+		index, found, err = DownloadTargetIndex(client, service, releaseChannel, role)
+		indexSpan.SetAttributes(tracing.AttrBytes.Int(len(index)))
+		tracing.End(indexSpan, err)
+		return err
+	})
+	span.SetAttributes(tracing.AttrVersion.String(report.IndexVersion(index, service)))
+	tracing.End(span, err)
+	return index, found, err
+}
+
+// watchCheckRequests triggers a check every time one is requested through update_status.json.
+func watchCheckRequests(ctx context.Context, scheduler *schedule.Scheduler) {
+	ticker := time.NewTicker(statusPollDelay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s, err := status.Read(jsonPath)
+			if err != nil || s.CheckRequested == 0 {
+				continue
 			}
+			if err := status.Update(jsonPath, func(s *status.Status) { s.CheckRequested = 0 }); err != nil {
+				slog.Error("Failed to update update_status.json", logging.Phase, logging.PhaseCheck, "error", err)
+			}
+			scheduler.Trigger()
+		case <-ctx.Done():
+			return
 		}
-		time.Sleep(checkDelay)
 	}
 }
 
-// InitEnvironment, InitTrustOnFirstUse, DownloadTargetIndex and setUpdateStatus
+// InitEnvironment, InitTrustOnFirstUse and DownloadTargetIndex
 // are similar to your original updater functions. You can place them here or split them into
 // multiple files if desired.
 
@@ -97,7 +338,7 @@ func InitEnvironment() (string, error) {
 	return tmpDir, nil
 }
 
-func InitTrustOnFirstUse(metadataDir string) error {
+func InitTrustOnFirstUse(client *http.Client, metadataURL, metadataDir string) error {
 	rootPath := filepath.Join(metadataDir, "root.json")
 	if _, err := os.Stat(rootPath); err == nil {
 		return nil
@@ -106,7 +347,7 @@ func InitTrustOnFirstUse(metadataDir string) error {
 	if err != nil {
 		return fmt.Errorf("failed to create URL for 1.root.json: %w", err)
 	}
-	resp, err := http.Get(rootURL)
+	resp, err := client.Get(rootURL)
 	if err != nil {
 		return fmt.Errorf("failed to GET 1.root.json: %w", err)
 	}
@@ -118,61 +359,152 @@ func InitTrustOnFirstUse(metadataDir string) error {
 	return os.WriteFile(rootPath, data, 0644)
 }
 
-func DownloadTargetIndex(metadataDir, service string) ([]byte, int, error) {
-	serviceFilePath := filepath.Join(service, fmt.Sprintf("%s-index.json", service))
-	fmt.Printf("DEBUG: serviceFilePath: %s\n", serviceFilePath)
-	rootBytes, err := os.ReadFile(filepath.Join(metadataDir, "root.json"))
+func DownloadTargetIndex(client *tufclient.Client, service, releaseChannel, role string) ([]byte, int, error) {
+	serviceFilePath := channel.IndexPath(service, releaseChannel)
+	slog.Debug("Downloading target index", logging.Service, service, logging.Phase, logging.PhaseCheck, "path", serviceFilePath)
+	tb, cached, err := client.DownloadDelegatedTarget(serviceFilePath, indexFile(client, service), role)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("failed to download target index: %w", err)
 	}
-	cfg, err := config.New(metadataURL, rootBytes)
-	if err != nil {
-		return nil, 0, err
+	if cached {
+		slog.Debug("Target index cached", logging.Service, service, logging.Phase, logging.PhaseCheck, "path", serviceFilePath)
+		return tb, 1, nil
 	}
-	cwd, err := os.Getwd()
-	if err != nil {
-		return nil, 0, err
+	return tb, 0, nil
+}
+
+// indexFile returns where client downloads the index of service.
+func indexFile(client *tufclient.Client, service string) string {
+	return filepath.Join(client.TargetsDir(), service, fmt.Sprintf("%s-index.json", service))
+}
+
+// updatePending reports whether there is an update offered to this host or held back from it.
+func updatePending() bool {
+	s, err := status.Read(jsonPath)
+	return err == nil && (s.UpdateAvailable == 1 || s.RolloutPending || s.HeldBack != "")
+}
+
+// offerUpdate offers the update described by indexData unless the index has no artifact for this host, its
+// version is blocklisted, outside the pin or older than the running one, or its staged rollout has not reached
+// this host yet. The pin and blocklist selected in update_status.json override and extend the configured ones.
+func offerUpdate(r *report.Reporter, cfg Config, indexData []byte, now time.Time) error {
+	var data map[string]struct {
+		Schema      int              `json:"schema,omitempty"`
+		Artifacts   []index.Artifact `json:"artifacts,omitempty"`
+		Version     string           `json:"version"`
+		ReleaseDate string           `json:"release-date"`
+		Rollout     *rollout.Rollout `json:"rollout,omitempty"`
+	}
+	log := slog.With(logging.Service, r.Service, logging.Phase, logging.PhaseCheck)
+	if err := json.Unmarshal(indexData, &data); err != nil {
+		log.Error("Failed to parse the index", "error", err)
 	}
-	cfg.LocalMetadataDir = metadataDir
-	cfg.LocalTargetsDir = filepath.Join(cwd, "data")
-	cfg.RemoteTargetsURL = targetsURL
-	cfg.PrefixTargetsWithHash = true
+	info := data[r.Service]
 
-	up, err := updater.New(cfg)
+	return r.Offer(report.Offer{
+		Version:   info.Version,
+		Rollout:   info.Rollout,
+		Pin:       cfg.Pin,
+		Blocklist: cfg.Blocklist,
+		Hold: func(s status.Status, rules pin.Rules) error {
+			if err := checkArtifacts(info.Schema, info.Artifacts, cfg.ArtifactVariant); err != nil {
+				return err
+			}
+			if err := info.Rollout.Validate(); err != nil {
+				return err
+			}
+			if err := rules.Check(info.Version); err != nil {
+				return err
+			}
+			return checkDowngrade(cfg.Version, info.Version, info.ReleaseDate, s.Rollback)
+		},
+	}, now, log)
+}
+
+// applyUpdatePolicy requests the available update of the service of r when its policy says so, and
+// stores when that will happen in update_status.json.
+func applyUpdatePolicy(r *report.Reporter, p policy.Policy, index []byte, now time.Time) error {
+	service := r.Service
+	s, err := status.Read(r.StatusPath)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create updater: %w", err)
+		return err
 	}
-	if err = up.Refresh(); err != nil {
-		return nil, 0, fmt.Errorf("failed to refresh metadata: %w", err)
+
+	// an update that failed is not requested automatically again
+	var next *time.Time
+	if s.UpdateAvailable == 1 && s.LastError == "" {
+		var data map[string]struct {
+			ReleaseDate string `json:"release-date"`
+		}
+		if err := json.Unmarshal(index, &data); err != nil {
+			return fmt.Errorf("error parsing the index: %w", err)
+		}
+		release, err := releaseDate(r.TUF, service, data[service].ReleaseDate)
+		if err != nil {
+			return err
+		}
+		if t, ok := p.NextApply(release, now); ok {
+			next = &t
+		}
 	}
-	ti, err := up.GetTargetInfo(serviceFilePath)
-	if err != nil {
-		return nil, 0, fmt.Errorf("failed to get target info: %w", err)
+
+	requested := false
+	err = status.Update(r.StatusPath, func(s *status.Status) {
+		s.UpdatePolicy = p.String()
+		s.NextAutoApply = next
+		if next != nil && !next.After(now) && s.UpdateRequested != 1 {
+			s.UpdateRequested = 1
+			s.RequestSource = history.SourcePolicy
+			s.RequestUser = ""
+			s.RequestTrace = ""
+			requested = true
+		}
+	})
+	if err == nil && requested {
+		r.Record(history.Entry{Event: history.EventRequest, Version: report.IndexVersion(index, service), Source: history.SourcePolicy, Detail: p.String(), Outcome: history.OutcomeSuccess}, slog.With(logging.Service, service, logging.Phase, logging.PhaseCheck))
 	}
-	os.Mkdir(filepath.Join(cwd, "data", service), 0750)
-	targetPath := filepath.Join(cwd, "data", service, fmt.Sprintf("%s-index.json", service))
-	os.MkdirAll(filepath.Dir(targetPath), 0750)
-	path, tb, err := up.FindCachedTarget(ti, targetPath)
-	fmt.Printf("DEBUG: Cached target file: %s\n", path)
+	return err
+}
+
+// releaseDate parses the release date of the index of service. When the index does not have a valid
+// one, the time client downloaded the index is used, as it is only written again for a new index.
+func releaseDate(client *tufclient.Client, service, date string) (time.Time, error) {
+	if release, err := policy.ParseReleaseDate(date); err == nil {
+		return release, nil
+	}
+	info, err := os.Stat(indexFile(client, service))
 	if err != nil {
-		return nil, 0, fmt.Errorf("error checking cache: %w", err)
+		return time.Time{}, fmt.Errorf("failed to read the download time of the index: %w", err)
 	}
-	if path != "" {
-		fmt.Println("CACHE HIT")
-		return tb, 1, nil
+	return info.ModTime(), nil
+}
+
+// checkDowngrade returns an error if the candidate version is older than the running one and no rollback
+// was requested. Development builds, whose version is not a release tag, accept any version.
+func checkDowngrade(running, candidate, releaseDate string, rollback bool) error {
+	current, err := version.Parse(running)
+	if err != nil {
+		return nil
 	}
-	_, tb, err = up.DownloadTarget(ti, targetPath, "")
+	next, err := version.Parse(candidate)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to download target index: %w", err)
+		return err
 	}
-	return tb, 0, nil
+	if release, err := policy.ParseReleaseDate(releaseDate); err == nil {
+		next = next.WithRelease(release)
+	}
+	return version.CheckUpgrade(current, next, rollback)
 }
 
-func setUpdateStatus(value int) error {
-	status := UpdateStatus{UpdateAvailable: value}
-	data, err := json.MarshalIndent(status, "", "  ")
-	if err != nil {
+// checkArtifacts returns an error if the index schema is not supported or, for indexes listing one
+// artifact per platform, if none is built for this host.
+func checkArtifacts(schema int, artifacts []index.Artifact, variant string) error {
+	if err := index.CheckSchema(schema); err != nil {
 		return err
 	}
-	return os.WriteFile(jsonPath, data, 0644)
+	if len(artifacts) == 0 {
+		return nil
+	}
+	_, err := index.Select(artifacts, runtime.GOOS, runtime.GOARCH, variant)
+	return err
 }
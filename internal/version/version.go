@@ -0,0 +1,116 @@
+// Package version parses and orders the release tags of the services,
+// vYYYY.MM.DD-sha.xxxxxxx as created by make-release.sh.
+package version
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ErrDowngrade is returned when the candidate version is older than the
+// installed one and no rollback was requested.
+var ErrDowngrade = errors.New("refusing to downgrade")
+
+var tagRegex = regexp.MustCompile(`^v(\d{4}\.\d{2}\.\d{2})-sha\.([a-fA-F0-9]{7,40})$`)
+
+// Version is a parsed release tag.
+type Version struct {
+	tag  string
+	date time.Time
+	sha  string
+	// released breaks the tie between releases of the same day
+	released time.Time
+}
+
+// Parse parses a release tag.
+func Parse(tag string) (Version, error) {
+	m := tagRegex.FindStringSubmatch(tag)
+	if m == nil {
+		return Version{}, fmt.Errorf("invalid version %q, expected vYYYY.MM.DD-sha.xxxxxxx", tag)
+	}
+	date, err := time.Parse("2006.01.02", m[1])
+	if err != nil {
+		return Version{}, fmt.Errorf("invalid version %q: %w", tag, err)
+	}
+	return Version{tag: tag, date: date, sha: m[2]}, nil
+}
+
+// IsTag reports whether s is a valid release tag.
+func IsTag(s string) bool {
+	_, err := Parse(s)
+	return err == nil
+}
+
+// WithRelease returns v with the time it was released, which orders the
+// releases of the same day. Use the release-date of the index, also recorded
+// when the version is installed, so that both versions compared are ordered
+// by the same clock.
+func (v Version) WithRelease(t time.Time) Version {
+	v.released = t
+	return v
+}
+
+// String returns the tag.
+func (v Version) String() string {
+	return v.tag
+}
+
+// Date returns the day of the release.
+func (v Version) Date() time.Time {
+	return v.date
+}
+
+// Compare returns -1, 0 or +1 depending on whether v is older than, the same
+// as or newer than w. Releases of the same day are ordered by their release
+// time, the ones whose release time is unknown first, and then by their hash,
+// so that the order is total whichever release times are known. A commit is
+// expected to have a single release time.
+func (v Version) Compare(w Version) int {
+	if c := v.date.Compare(w.date); c != 0 {
+		return c
+	}
+	if v.sha == w.sha {
+		return 0
+	}
+	if c := v.released.Compare(w.released); c != 0 {
+		return c
+	}
+	switch {
+	case v.sha < w.sha:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Sort sorts vs from the oldest to the newest version.
+func Sort(vs []Version) {
+	sort.SliceStable(vs, func(i, j int) bool {
+		return vs[i].Compare(vs[j]) < 0
+	})
+}
+
+// Before reports whether v is known to be older than w. Unlike Compare, the
+// releases of the same day are only ordered when both release times are known.
+func (v Version) Before(w Version) bool {
+	if !v.date.Equal(w.date) {
+		return v.date.Before(w.date)
+	}
+	if v.released.IsZero() || w.released.IsZero() {
+		return false
+	}
+	return v.released.Before(w.released)
+}
+
+// CheckUpgrade returns an error wrapping ErrDowngrade if candidate is older
+// than current, unless rollback is set. Installing the current version again
+// is allowed.
+func CheckUpgrade(current, candidate Version, rollback bool) error {
+	if rollback || !candidate.Before(current) {
+		return nil
+	}
+	return fmt.Errorf("%w from %s to %s", ErrDowngrade, current, candidate)
+}
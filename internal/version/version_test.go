@@ -0,0 +1,199 @@
+package version
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func mustParse(t *testing.T, tag string) Version {
+	t.Helper()
+	v, err := Parse(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func at(hour int) time.Time {
+	return time.Date(2025, 3, 1, hour, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	for _, tag := range []string{
+		"v2025.03.01-sha.1a2b3c4",
+		"v2025.03.01-sha.1a2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d7e8f9a0b",
+	} {
+		if !IsTag(tag) {
+			t.Errorf("IsTag(%q) = false", tag)
+		}
+	}
+	for _, tag := range []string{
+		"",
+		"2025.03.01-sha.1a2b3c4",
+		"v2025.03.01",
+		"v2025.3.1-sha.1a2b3c4",
+		"v2025.13.01-sha.1a2b3c4",
+		"v2025.03.01-sha.1a2b3c",
+		"v2025.03.01-sha.1a2b3cg",
+		"v2025.03.01-sha.1a2b3c4-dirty",
+	} {
+		if IsTag(tag) {
+			t.Errorf("IsTag(%q) = true", tag)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		v, w Version
+		want int
+	}{
+		{
+			name: "older day",
+			v:    mustParse(t, "v2025.02.28-sha.fffffff"),
+			w:    mustParse(t, "v2025.03.01-sha.0000000"),
+			want: -1,
+		},
+		{
+			name: "day before release time",
+			v:    mustParse(t, "v2025.03.02-sha.0000000").WithRelease(at(0)),
+			w:    mustParse(t, "v2025.03.01-sha.fffffff").WithRelease(at(23)),
+			want: 1,
+		},
+		{
+			name: "same commit",
+			v:    mustParse(t, "v2025.03.01-sha.1a2b3c4").WithRelease(at(10)),
+			w:    mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+			want: 0,
+		},
+		{
+			name: "same day by release time",
+			v:    mustParse(t, "v2025.03.01-sha.fffffff").WithRelease(at(9)),
+			w:    mustParse(t, "v2025.03.01-sha.0000000").WithRelease(at(10)),
+			want: -1,
+		},
+		{
+			name: "same day unknown release time first",
+			v:    mustParse(t, "v2025.03.01-sha.fffffff"),
+			w:    mustParse(t, "v2025.03.01-sha.0000000").WithRelease(at(10)),
+			want: -1,
+		},
+		{
+			name: "same day by hash",
+			v:    mustParse(t, "v2025.03.01-sha.0000000"),
+			w:    mustParse(t, "v2025.03.01-sha.fffffff"),
+			want: -1,
+		},
+		{
+			name: "same release time by hash",
+			v:    mustParse(t, "v2025.03.01-sha.fffffff").WithRelease(at(10)),
+			w:    mustParse(t, "v2025.03.01-sha.0000000").WithRelease(at(10)),
+			want: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.v.Compare(tt.w); got != tt.want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", tt.v, tt.w, got, tt.want)
+			}
+			if got := tt.w.Compare(tt.v); got != -tt.want {
+				t.Errorf("%s.Compare(%s) = %d, want %d", tt.w, tt.v, got, -tt.want)
+			}
+		})
+	}
+}
+
+// TestCompareTotal checks that the order is transitive when the release time
+// is only known for some releases of the same day.
+func TestCompareTotal(t *testing.T) {
+	vs := []Version{
+		mustParse(t, "v2025.03.01-sha.aaaaaaa").WithRelease(at(10)),
+		mustParse(t, "v2025.03.01-sha.bbbbbbb"),
+		mustParse(t, "v2025.03.01-sha.ccccccc").WithRelease(at(9)),
+		mustParse(t, "v2025.03.01-sha.ddddddd"),
+		mustParse(t, "v2025.03.02-sha.0000000"),
+		mustParse(t, "v2025.02.28-sha.fffffff").WithRelease(at(12)),
+	}
+	for _, a := range vs {
+		for _, b := range vs {
+			for _, c := range vs {
+				if a.Compare(b) < 0 && b.Compare(c) < 0 && a.Compare(c) >= 0 {
+					t.Errorf("%s < %s < %s but %s.Compare(%s) = %d", a, b, c, a, c, a.Compare(c))
+				}
+			}
+		}
+	}
+
+	Sort(vs)
+	want := []string{
+		"v2025.02.28-sha.fffffff",
+		"v2025.03.01-sha.bbbbbbb",
+		"v2025.03.01-sha.ddddddd",
+		"v2025.03.01-sha.ccccccc",
+		"v2025.03.01-sha.aaaaaaa",
+		"v2025.03.02-sha.0000000",
+	}
+	for i, v := range vs {
+		if v.String() != want[i] {
+			t.Errorf("Sort()[%d] = %s, want %s", i, v, want[i])
+		}
+	}
+}
+
+func TestCheckUpgrade(t *testing.T) {
+	tests := []struct {
+		name               string
+		current, candidate Version
+		rollback           bool
+		wantErr            bool
+	}{
+		{
+			name:      "newer day",
+			current:   mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+			candidate: mustParse(t, "v2025.03.02-sha.0000000"),
+		},
+		{
+			name:      "older day",
+			current:   mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+			candidate: mustParse(t, "v2025.02.28-sha.fffffff"),
+			wantErr:   true,
+		},
+		{
+			name:      "older day with rollback",
+			current:   mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+			candidate: mustParse(t, "v2025.02.28-sha.fffffff"),
+			rollback:  true,
+		},
+		{
+			name:      "same version",
+			current:   mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+			candidate: mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+		},
+		{
+			name:      "older release of the same day",
+			current:   mustParse(t, "v2025.03.01-sha.1a2b3c4").WithRelease(at(10)),
+			candidate: mustParse(t, "v2025.03.01-sha.fffffff").WithRelease(at(9)),
+			wantErr:   true,
+		},
+		{
+			name:      "same day without release time",
+			current:   mustParse(t, "v2025.03.01-sha.fffffff").WithRelease(at(10)),
+			candidate: mustParse(t, "v2025.03.01-sha.1a2b3c4"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckUpgrade(tt.current, tt.candidate, tt.rollback)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckUpgrade() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDowngrade) {
+				t.Errorf("CheckUpgrade() error = %v, want ErrDowngrade", err)
+			}
+		})
+	}
+}
@@ -1,130 +1,420 @@
 package main
 
 import (
-	"archive/zip"
 	"context"
 	"crypto/sha256"
+	"debug/elf"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-logr/stdr"
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/ffyaml"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 
 	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/sorayaormazabalmayo/general-service/internal/archive"
+	"github.com/sorayaormazabalmayo/general-service/internal/channel"
+	"github.com/sorayaormazabalmayo/general-service/internal/delta"
+	"github.com/sorayaormazabalmayo/general-service/internal/history"
+	"github.com/sorayaormazabalmayo/general-service/internal/hostid"
+	"github.com/sorayaormazabalmayo/general-service/internal/httpclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/index"
+	"github.com/sorayaormazabalmayo/general-service/internal/logging"
+	"github.com/sorayaormazabalmayo/general-service/internal/notify"
+	"github.com/sorayaormazabalmayo/general-service/internal/p2p"
+	"github.com/sorayaormazabalmayo/general-service/internal/pin"
+	"github.com/sorayaormazabalmayo/general-service/internal/policy"
+	"github.com/sorayaormazabalmayo/general-service/internal/ratelimit"
+	"github.com/sorayaormazabalmayo/general-service/internal/report"
+	"github.com/sorayaormazabalmayo/general-service/internal/retry"
+	"github.com/sorayaormazabalmayo/general-service/internal/rollout"
+	"github.com/sorayaormazabalmayo/general-service/internal/schedule"
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
+	"github.com/sorayaormazabalmayo/general-service/internal/tufclient"
+	"github.com/sorayaormazabalmayo/general-service/internal/version"
 	"github.com/theupdateframework/go-tuf/v2/metadata"
-	"github.com/theupdateframework/go-tuf/v2/metadata/config"
-	"github.com/theupdateframework/go-tuf/v2/metadata/updater"
 )
 
-// The following config is used to fetch a target from Jussi's GitHub repository example
 const (
-	metadataURL          = "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/metadata"
-	targetsURL           = "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/targets"
-	verbosity            = 4
 	generateRandomFolder = false
+
+	// releaseMetadataFile is written in every version directory with the release date of the version
+	releaseMetadataFile = ".release.json"
 )
 
 var (
+	// The following config is used to fetch a target from Jussi's GitHub repository example, or from a
+	// mirror of it. The trusted root is the same either way
+	metadataURL string
+	targetsURL  string
+
 	serviceAccountKeyPath = "/home/sormazabal/artifact-downloader-key.json"
 	jsonFilePath          = "/home/sormazabal/src/SALTO2/update_status.json"
+	historyFilePath       = "/home/sormazabal/src/SALTO2/update_history.jsonl"
 	service               = "general-service"
 	targetIndexFile       = "/home/sormazabal/src/SALTO2/data/general-service/general-service-index.json"
 	newBinaryPath         = "/home/sormazabal/src/SALTO2/tmp/general-service.zip"
 	destinationPath       = "/home/sormazabal/src/SALTO2/general-service.zip"
-	SALTOLocation         = "/home/sormazabal/src/SALTO2"
+	deltaPath             = "/home/sormazabal/src/SALTO2/tmp/general-service-delta"
+	SALTOLocation         = status.DefaultDir
 	linkNameService       = "/usr/local/bin/general-service"
 	linkNameConfig        = "/etc/general-service/general-service.yml"
+	hostIDPath            = "/home/sormazabal/src/SALTO2/host-id"
+
+	// stable identity of the host, deciding when it takes part in a staged rollout
+	hostID string
+
+	// scheduling of the update checks
+	checkDelay   time.Duration
+	checkSplay   time.Duration
+	checkWindows []string
+
+	// maximum time between two refreshes of the TUF metadata by scheduled checks
+	refreshDelay time.Duration
+
+	// release channel followed by default and roles expected to sign each channel
+	defaultChannel   string
+	channelRoleSpecs []string
+	channelRoles     channel.Roles
+
+	// rate limit of every download, metadata and artifacts
+	downloadRate        string
+	downloadBurst       string
+	downloadRateWindows []string
+	downloadLimiter     *ratelimit.Limiter
+
+	// background download of the available updates, limited to prefetchRate bytes per second
+	prefetchUpdates bool
+	prefetchRate    uint64
+
+	// sharing of the verified artifacts with the other hosts of the LAN, tried before the origin
+	p2pEnabled   bool
+	p2pAddr      string
+	p2pPeers     []string
+	p2pMDNS      bool
+	p2pTokenPath string
+	peers        *p2p.Node
+
+	// records the checks and the updates in update_status.json, in the update history and to the webhooks
+	reporter *report.Reporter
+
+	// variant of the artifacts preferred for this host, e.g. musl
+	artifactVariant string
+
+	// version the service is pinned to and versions that must never be installed
+	pinVersion     string
+	blockedVersion []string
+
+	// policies deciding when the available updates are applied automatically
+	updatePolicySpecs []string
+	updatePolicies    policy.Set
+
+	// HTTP transport used for TUF and artifact downloads
+	httpProxy          string
+	caBundlePath       string
+	clientCertPath     string
+	clientKeyPath      string
+	httpConnectTimeout time.Duration
+	httpReadTimeout    time.Duration
+
+	// level, format and file of the logs
+	logConfig logging.Config
+
+	// OTLP export of the traces of the update pipeline
+	traceConfig tracing.Config
+
+	// time the restarted service has to become active before the update is deemed failed
+	healthCheckTimeout time.Duration
+
+	// webhooks notified of the update events, with the deliveries signed with the secret of the file
+	webhookURLs       []string
+	webhookSecretPath string
 )
 
-// struct to store update status
-type UpdateStatus struct {
-	UpdateAvailable int `json:"update_available"`
-	UpdateRequested int `json:"update_requested"`
-}
+// retry policies for the update checks and for the artifact downloads of an update
+var (
+	checkRetryPolicy    = retry.Policy{InitialInterval: 2 * time.Second, MaxInterval: 30 * time.Second, Jitter: 0.5, MaxAttempts: 3}
+	downloadRetryPolicy = retry.DefaultPolicy
+)
+
+// progressInterval is how often the progress of the artifact downloads is logged.
+const progressInterval = 5 * time.Second
+
+var (
+	// errHashMismatch is returned when the downloaded artifact does not match the hash of the index.
+	errHashMismatch = errors.New("the hashes do not match")
+	// errMetadataExpired is returned when an update is requested while the trusted metadata is expired.
+	errMetadataExpired = errors.New("the TUF metadata has expired")
+)
 
 // indexInfo is the structure in which the information from the general-service.json is stored.
+// Schema 1 indexes describe a single artifact with Bytes, Path, Hashes and an optional Delta, schema 2
+// indexes list the artifacts of every platform in Artifacts.
 type indexInfo struct {
-	Bytes  string `json:"bytes"`
-	Path   string `json:"path"`
-	Hashes struct {
-		Sha256 string `json:"sha256"`
-	} `json:"hashes"`
-	Version     string `json:"version"`
-	ReleaseDate string `json:"release-date"`
+	Schema      int              `json:"schema,omitempty"`
+	Bytes       string           `json:"bytes"`
+	Path        string           `json:"path"`
+	Hashes      index.Hashes     `json:"hashes"`
+	Delta       *index.Delta     `json:"delta,omitempty"`
+	Artifacts   []index.Artifact `json:"artifacts,omitempty"`
+	Version     string           `json:"version"`
+	ReleaseDate string           `json:"release-date"`
+	Rollout     *rollout.Rollout `json:"rollout,omitempty"`
 }
 
-// Main program
-func main() {
+// artifact returns the artifact of the update built for the platform of this host.
+func (i indexInfo) artifact() (index.Artifact, error) {
+	if err := index.CheckSchema(i.Schema); err != nil {
+		return index.Artifact{}, err
+	}
+	if len(i.Artifacts) == 0 {
+		return index.Artifact{Path: i.Path, Bytes: i.Bytes, Hashes: i.Hashes, Delta: i.Delta}, nil
+	}
+	return index.Select(i.Artifacts, runtime.GOOS, runtime.GOARCH, artifactVariant)
+}
 
-	// First, a lof file will be opened in append mode, create if does not exist
+// parseFlags reads the updater configuration from the command line, the environment
+// (GENERAL_SERVICE_UPDATER_ prefix) and the optional YAML file given with -config.
+func parseFlags(args []string) error {
+	fs := ff.NewFlagSet("updater")
+	_ = fs.String(0, "config", "", "config file in yaml format")
+	fs.StringVar(&metadataURL, 0, "metadata-url", "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/metadata", "TUF metadata URL, of the repository or of a mirror")
+	fs.StringVar(&targetsURL, 0, "targets-url", "https://sorayaormazabalmayo.github.io/TUF_Repository_YubiKey_Vault/targets", "TUF targets URL, of the repository or of a mirror")
+	fs.StringVar(&httpProxy, 0, "http-proxy", "", "HTTPS proxy URL, HTTPS_PROXY is used when empty")
+	fs.StringVar(&caBundlePath, 0, "ca-bundle", "", "PEM file with extra trusted CA certificates")
+	fs.StringVar(&clientCertPath, 0, "client-cert", "", "PEM client certificate for mutual TLS")
+	fs.StringVar(&clientKeyPath, 0, "client-key", "", "PEM client key for mutual TLS")
+	fs.DurationVar(&httpConnectTimeout, 0, "http-connect-timeout", 10*time.Second, "HTTP connect timeout")
+	fs.DurationVar(&httpReadTimeout, 0, "http-read-timeout", 30*time.Second, "HTTP response timeout")
+	fs.DurationVar(&checkDelay, 0, "check-interval", 60*time.Second, "base interval between update checks")
+	fs.DurationVar(&checkSplay, 0, "check-splay", 30*time.Second, "maximum random delay added to every check interval")
+	fs.DurationVar(&refreshDelay, 0, "metadata-refresh-interval", 15*time.Minute, "maximum time between two refreshes of the TUF metadata, checks requested from the UI or the CLI always refresh")
+	fs.StringListVar(&checkWindows, 0, "check-window", "cron-style window in which checks are allowed, e.g. \"* 2-4 * * *\" (repeatable)")
+
+	fs.StringVar(&defaultChannel, 0, "channel", channel.Stable, "release channel followed unless another one is selected from the UI or the CLI")
+	fs.StringListVar(&channelRoleSpecs, 0, "channel-role", "<channel>=<role> delegated role expected to sign the index of a channel (repeatable)")
+	fs.StringVar(&downloadRate, 0, "download-rate", "", "maximum download bytes per second, e.g. 512K, empty for no limit")
+	fs.StringVar(&downloadBurst, 0, "download-burst", "", "bytes that may be downloaded at once above the rate, e.g. 64K")
+	fs.StringListVar(&downloadRateWindows, 0, "download-rate-window", "<HH:MM>-<HH:MM>=<rate> download rate at some times of the day, 0 for no limit (repeatable)")
+	fs.BoolVarDefault(&prefetchUpdates, 0, "prefetch", true, "download and verify the available updates before they are requested")
+	fs.Uint64Var(&prefetchRate, 0, "prefetch-rate", 0, "maximum bytes per second used to prefetch updates, 0 for no limit")
+	fs.BoolVar(&p2pEnabled, 0, "p2p", "share the verified artifacts with the other hosts of the LAN and fetch them from there first")
+	fs.StringVar(&p2pAddr, 0, "p2p-addr", ":8765", "address where the verified artifacts are served to the peers")
+	fs.StringListVar(&p2pPeers, 0, "p2p-peer", "<host>:<port> of a peer sharing artifacts (repeatable)")
+	fs.BoolVarDefault(&p2pMDNS, 0, "p2p-mdns", true, "advertise and discover the peers with mDNS")
+	fs.StringVar(&p2pTokenPath, 0, "p2p-token-file", "", "file holding the token shared by the peers of the site, required with --p2p")
+	fs.StringVar(&artifactVariant, 0, "artifact-variant", "", "variant of the artifacts preferred for this host, the default build is used when there is none")
+	fs.StringVar(&pinVersion, 0, "pin-version", "", "version or range, e.g. \">=v2025.03.01 <v2025.04.01\", the service is held at")
+	fs.StringListVar(&blockedVersion, 0, "block-version", "version that must never be installed (repeatable)")
+	fs.StringListVar(&updatePolicySpecs, 0, "update-policy", "[service=]manual|immediate|soak:<delay>|window:<days> <HH:MM>-<HH:MM> [<timezone>] (repeatable)")
+	fs.StringVar(&logConfig.Level, 0, "log-level", "info", "minimum level logged: debug, info, warn or error")
+	fs.StringVar(&logConfig.Format, 0, "log-format", logging.FormatText, "format of the logs: text or json")
+	fs.StringVar(&logConfig.Path, 0, "log-file", filepath.Join(SALTOLocation, "nebula_tuf_client.log"), "file the logs are written to besides stdout, empty for none")
+	fs.StringVar(&logConfig.Output, 0, "log-output", logging.OutputStdout, "where the logs are written: stdout (and the log file), journald, or auto to use journald under systemd")
+	fs.StringVar(&logConfig.Rotation.MaxSize, 0, "log-max-size", "10M", "size past which the log file is rotated, e.g. 10M, empty for no limit")
+	fs.DurationVar(&logConfig.Rotation.MaxAge, 0, "log-max-age", 24*time.Hour, "time after which the log file is rotated, 0 for no limit")
+	fs.IntVar(&logConfig.Rotation.MaxBackups, 0, "log-max-backups", 7, "rotated log files kept, 0 to keep all of them")
+	fs.BoolVarDefault(&logConfig.Rotation.Compress, 0, "log-compress", true, "gzip the rotated log files")
+	fs.StringVar(&traceConfig.Endpoint, 0, "otlp-endpoint", "", "<host>:<port> of the OTLP gRPC collector the traces are exported to, empty for no tracing")
+	fs.BoolVar(&traceConfig.Insecure, 0, "otlp-insecure", "connect to the OTLP collector without TLS")
+	fs.DurationVar(&healthCheckTimeout, 0, "health-check-timeout", 30*time.Second, "time the restarted service has to become active")
+	fs.StringListVar(&webhookURLs, 0, "webhook-url", "URL called when an update is available, applied, failed or rolled back (repeatable)")
+	fs.StringVar(&webhookSecretPath, 0, "webhook-secret-file", "", "file with the secret signing the webhook calls with HMAC-SHA256, empty for unsigned calls")
+
+	err := ff.Parse(fs, args,
+		ff.WithEnvVarPrefix("GENERAL_SERVICE_UPDATER"),
+		ff.WithConfigFileFlag("config"),
+		ff.WithConfigFileParser(ffyaml.Parse),
+	)
+	if err != nil {
+		return err
+	}
 
-	// Setting Logger's file location
-	logFileLocation := filepath.Join(SALTOLocation, "nebula_tuf_client.log")
+	if err := channel.Validate(defaultChannel); err != nil {
+		return err
+	}
+	if channelRoles, err = channel.ParseRoles(channelRoleSpecs); err != nil {
+		return err
+	}
 
-	logFile, err := os.OpenFile(logFileLocation, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Fatalf("Failed to open log file: %v", err)
+	if _, err := pin.NewRules(pinVersion, blockedVersion); err != nil {
+		return err
 	}
-	defer logFile.Close() // Ensure file is closed when program exits
 
-	// Create a MultiWriter to log to both stdout and file
-	multiWriter := io.MultiWriter(os.Stdout, logFile)
+	rateLimit, err := ratelimit.ParseConfig(downloadRate, downloadBurst, downloadRateWindows)
+	if err != nil {
+		return err
+	}
+	downloadLimiter = ratelimit.NewWithConfig(rateLimit)
 
-	// Create logger 1 for TUF that writes to both destinations
-	stdLogger1 := log.New(multiWriter, "Nebula TUF Client Logger:", log.LstdFlags)
+	updatePolicies, err = policy.ParseSet(updatePolicySpecs)
+	return err
+}
 
-	// Set logger to use both stdout and file
-	metadata.SetLogger(stdr.New(stdLogger1))
+// Main program
+func main() {
 
-	// Set verbosity level
-	stdr.SetVerbosity(verbosity)
+	if err := parseFlags(os.Args[1:]); err != nil {
+		slog.Error("Failed to parse flags", "error", err)
+		os.Exit(1)
+	}
 
-	// Retrieve and use logger
-	tufLog := metadata.GetLogger()
+	// Setting the logger, writing to both stdout and the log file. go-tuf logs through it too
+	logger, closer, err := logging.New(logConfig)
+	if err != nil {
+		slog.Error("Failed to create the logger", "error", err)
+		os.Exit(1)
+	}
+	defer closer.Close() // Ensure file is closed when program exits
 
-	// Creating logger 2 for getting information of other staff not related as much to TUF
-	generalLog := log.New(multiWriter, "Updater General Logger: ", log.LstdFlags)
+	logger = logger.With(logging.Service, service)
+	// the helpers logging through slog without a logger of their own write to it too
+	slog.SetDefault(logger)
+	metadata.SetLogger(logging.TUF(logger))
+	generalLog := logger.With(logging.Phase, logging.PhaseStartup)
 
 	// initialize environment - temporary folders, etc.
 	metadataDir, err := InitEnvironment()
 	if err != nil {
-		tufLog.Error(err, "Failed to initialize environment")
+		generalLog.Error("Failed to initialize environment", "error", err)
 	}
 
-	// initialize client with Trust-On-First-Use
-	err = InitTrustOnFirstUse(metadataDir)
+	// getting the current version
+	currentVersion, err := readCurrentVersion()
+
 	if err != nil {
-		tufLog.Error(err, "Trust-On-First-Use failed")
+		generalLog.Error("Failed to read the current version", "error", err)
 	}
 
-	// getting the current version
-	currentVersion, err := readCurrentVersion()
+	generalLog.Info("Current version", logging.Version, currentVersion)
 
+	// exporting the traces of the update pipeline, if a collector is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), traceConfig, currentVersion)
 	if err != nil {
-		generalLog.Printf("❌There has been an error wile reading the current version❌\n")
+		generalLog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
 	}
+	defer shutdownTracing(context.Background())
 
-	generalLog.Printf("🟣Current Version is %s🟣\n", currentVersion)
+	// getting the host ID used to decide when this host takes part in a staged rollout
+	hostID, err = hostid.Load(hostIDPath)
+	if err != nil {
+		generalLog.Error("Failed to load the host ID", "error", err)
+		os.Exit(1)
+	}
+	reporter = &report.Reporter{StatusPath: jsonFilePath, HistoryPath: historyFilePath, Service: service, Host: hostID}
+
+	// creating the HTTP client shared by TUF and the artifact downloads
+	httpClient, err := httpclient.New(httpclient.Config{
+		ProxyURL:       httpProxy,
+		CAFile:         caBundlePath,
+		CertFile:       clientCertPath,
+		KeyFile:        clientKeyPath,
+		ConnectTimeout: httpConnectTimeout,
+		ReadTimeout:    httpReadTimeout,
+		UserAgent:      httpclient.UserAgent(currentVersion),
+		Limiter:        downloadLimiter,
+	})
+	if err != nil {
+		generalLog.Error("Failed to create the HTTP client", "error", err)
+		os.Exit(1)
+	}
+
+	// sharing the verified artifacts with the peers. The updates are still downloaded from the origin
+	// if this fails
+	if p2pEnabled {
+		token, err := p2p.ReadToken(p2pTokenPath)
+		if err == nil {
+			peers, err = p2p.New(p2p.Config{
+				Addr:     p2pAddr,
+				Token:    token,
+				CacheDir: filepath.Join(SALTOLocation, "p2p"),
+				Peers:    p2pPeers,
+				MDNS:     p2pMDNS,
+			})
+		}
+		if err == nil {
+			err = peers.Start()
+		}
+		if err != nil {
+			generalLog.Error("Failed to share the artifacts with the peers", "error", err)
+			peers = nil
+		} else {
+			defer peers.Close()
+		}
+	}
+
+	// notifying the update events to the webhooks. The events are kept in the outbox until delivered
+	if len(webhookURLs) > 0 {
+		secret, err := notify.ReadSecret(webhookSecretPath)
+		if err == nil {
+			reporter.Notifier, err = notify.New(notify.Config{
+				URLs:   webhookURLs,
+				Secret: secret,
+				Outbox: filepath.Join(SALTOLocation, "outbox"),
+			})
+		}
+		if err != nil {
+			generalLog.Error("Failed to set up the webhooks", "error", err)
+			os.Exit(1)
+		}
+		go reporter.Notifier.Run(context.Background(), logger.With(logging.Phase, logging.PhaseNotify))
+	}
+
+	// initialize client with Trust-On-First-Use
+	err = InitTrustOnFirstUse(httpClient, metadataDir)
+	if err != nil {
+		generalLog.Error("Trust-On-First-Use failed", logging.Phase, logging.PhaseTUF, "error", err)
+	}
 
 	// getting the previous version folder
 	previousVersion, err := getPreviousVersion(currentVersion)
 
 	if err != nil {
-		generalLog.Printf("❌There has been an error wile reading the previous version❌\n")
+		generalLog.Warn("Failed to read the previous version", "error", err)
 	}
 
-	generalLog.Printf("🟣Previous Version is %s🟣\n", previousVersion)
+	generalLog.Info("Previous version", "previous", previousVersion)
+
+	// creating the TUF client that will be kept during the whole execution
+	tufClient, err := tufclient.New(tufclient.Config{
+		MetadataURL:     metadataURL,
+		TargetsURL:      targetsURL,
+		MetadataDir:     metadataDir,
+		TargetsDir:      filepath.Join(SALTOLocation, "data"),
+		RefreshInterval: refreshDelay,
+		HTTPClient:      httpClient,
+	})
+	if err != nil {
+		generalLog.Error("Failed to create the TUF client", "error", err)
+		os.Exit(1)
+	}
+	reporter.TUF = tufClient
+
+	// creating the scheduler of the update checks
+	scheduler, err := schedule.New(schedule.Config{
+		Interval: checkDelay,
+		Splay:    checkSplay,
+		Windows:  checkWindows,
+	})
+	if err != nil {
+		generalLog.Error("Failed to create the check scheduler", "error", err)
+		os.Exit(1)
+	}
 
 	var wg sync.WaitGroup
 	wg.Add(1)
@@ -133,30 +423,86 @@ func main() {
 	go func() {
 		defer wg.Done()
 
+		checkLog := logger.With(logging.Phase, logging.PhaseCheck)
+
+		// a triggered check refreshes the metadata even if it is not due yet
+		triggered := false
+
 		// the updater needs to be looking for new updates every x time
 		for {
 
-			// downloading general-service-index.json
-			_, foundDesiredTargetIndexLocally, err := DownloadTargetIndex(metadataDir, service)
+			checkStart := time.Now()
+			checkSource := history.SourceSchedule
+			if triggered {
+				checkSource = history.SourceRequest
+			}
+
+			var (
+				foundDesiredTargetIndexLocally int
+				index                          []byte
+			)
+
+			// refreshing the trusted metadata when it is due and downloading general-service-index.json,
+			// retrying the network errors with backoff
+			ctx, checkSpan := tracing.Start(context.Background(), tracing.SpanCheck, tracing.AttrService.String(service), tracing.AttrSource.String(checkSource))
+			err := retry.Do(ctx, checkRetryPolicy, tufclient.Retryable, func(err error, next time.Duration) {
+				logTUFError(checkLog, err, "Checking for updates failed", "retryIn", next)
+			}, func() error {
+				refresh := tufClient.RefreshIfDue
+				if triggered {
+					refresh = tufClient.Refresh
+				}
+				_, refreshSpan := tracing.Start(ctx, tracing.SpanRefresh)
+				_, err := refresh()
+				tracing.End(refreshSpan, err)
+				if err != nil {
+					return err
+				}
+				_, indexSpan := tracing.Start(ctx, tracing.SpanIndexDownload)
+				index, foundDesiredTargetIndexLocally, err = DownloadTargetIndex(tufClient, service, reporter.Channel(defaultChannel))
+				indexSpan.SetAttributes(tracing.AttrBytes.Int(len(index)))
+				tracing.End(indexSpan, err)
+				return err
+			})
+			checkSpan.SetAttributes(tracing.AttrVersion.String(report.IndexVersion(index, service)))
+			tracing.End(checkSpan, err)
+			reporter.Record(history.Entry{
+				Event:      history.EventCheck,
+				Version:    report.IndexVersion(index, service),
+				Source:     checkSource,
+				Outcome:    history.Outcome(err),
+				Error:      report.ErrorString(err),
+				DurationMS: history.Since(checkStart),
+			}, checkLog)
 
 			if err != nil {
-				tufLog.Error(err, "Download index file failed")
+				logTUFError(checkLog, err, "Download index file failed")
+			}
+			// the last error, once the retries are exhausted or for errors that are not retried
+			if err := reporter.SetCheckError(err); err != nil {
+				checkLog.Error("Failed to update update_status.json", "error", err)
 			}
 
-			// if there is a new one, this will mean that is initializing for the first time or that there is a new update
-			if foundDesiredTargetIndexLocally == 0 && err == nil {
-				err := setUpdateStatus(1)
-				if err != nil {
-					generalLog.Printf("❌ Error updating update_status.json:", err)
-				} else {
-					generalLog.Printf("✅ Successfully set update_status.json to update_available: 1")
+			// if there is a new one, this will mean that is initializing for the first time or that there is a new update.
+			// An update already offered or held back is evaluated again on every check, as the pin, the blocklist
+			// and the staged rollout may have changed.
+			if err == nil && (foundDesiredTargetIndexLocally == 0 || updatePending()) {
+				if err := offerUpdate(index, time.Now(), checkLog); err != nil {
+					checkLog.Error("Failed to update update_status.json", "error", err)
 				}
 
-			} else {
-				generalLog.Printf("The local index file is the most updated one\n")
+			} else if err == nil {
+				checkLog.Info("The local index file is up-to-date")
+			}
+
+			// past its expiry the metadata cannot be trusted and nothing new is installed until it is
+			// refreshed, or until an operator imports a bundle
+			if err := reporter.SetMetadataExpired(tufClient.Expires(), time.Now(), checkLog); err != nil {
+				checkLog.Error("Failed to update update_status.json", "error", err)
 			}
 
-			time.Sleep(time.Second * 60)
+			// waiting for the next scheduled check or for a check requested by the user
+			triggered, _ = scheduler.Wait(context.Background(), checkStart)
 
 		}
 	}()
@@ -167,13 +513,52 @@ func main() {
 	go func() {
 		defer wg.Done()
 
+		// version whose artifact has already been prefetched, or tried to
+		prefetchAttempted := ""
+
+		generalLog := logger.With(logging.Phase, logging.PhaseCheck)
+		prefetchLog := logger.With(logging.Phase, logging.PhasePrefetch)
+
 		for {
 
 			// every x time it will be reading if the user has requested a new update
 			updateRequested, err := ReadUpdateRequested(jsonFilePath)
 
 			if err != nil {
-				generalLog.Printf("There has been an error while reading the Update Requested Value: %f. \n", err)
+				generalLog.Error("Failed to read the update requested value", "error", err)
+			}
+
+			// the update policy of the service may also apply the available update without the user
+			autoApply, err := evaluateUpdatePolicy(time.Now())
+			if err != nil {
+				generalLog.Error("Failed to evaluate the update policy", "error", err)
+			}
+			autoApplied := false
+			if autoApply && updateRequested != 1 {
+				generalLog.Info("Applying the available update as set by the update policy", "policy", updatePolicies.For(service).String())
+				updateRequested = 1
+				autoApplied = true
+			}
+
+			// the user can also ask for an immediate update check
+			checkRequested, err := readCheckRequested(jsonFilePath)
+			if err != nil {
+				generalLog.Error("Failed to read the check requested value", "error", err)
+			}
+			if checkRequested {
+				generalLog.Info("Update check requested")
+				scheduler.Trigger()
+			}
+
+			// the available update is downloaded in the background, so that applying it only takes a local
+			// activation. It is attempted once per version, the update itself downloads it again if needed
+			if updateRequested != 1 && prefetchUpdates {
+				if v, err := prefetchUpdate(httpClient, prefetchAttempted, prefetchLog); err != nil {
+					prefetchLog.Warn("Prefetching the update failed", "available", v, "error", err)
+					prefetchAttempted = v
+				} else if v != "" {
+					prefetchAttempted = v
+				}
 			}
 
 			// if the user has pushed the botton, the new server should be executed.
@@ -181,106 +566,243 @@ func main() {
 
 				var data map[string]indexInfo
 
-				generalLog.Printf("The index file is located in: %s \n", targetIndexFile)
+				// the records and the history entries of this update attempt share an ID, from the download to
+				// the restart
+				updateID := logging.NewUpdateID()
+				updateStart := time.Now()
+				updateLog := logger.With(logging.UpdateID, updateID)
+				updateLog.Debug("Reading the index file", logging.Phase, logging.PhaseInstall, "path", targetIndexFile)
 
 				// read the actual JSON file content
 				fileContent, err := os.ReadFile(targetIndexFile)
 				if err != nil {
-					generalLog.Printf("Fail to read the index file \n")
+					updateLog.Error("Failed to read the index file", logging.Phase, logging.PhaseInstall, "error", err)
 				}
 
 				// parse JSON into the map
 				err = json.Unmarshal(fileContent, &data)
 				if err != nil {
-					generalLog.Printf("\U0001F534Error parsing JSON: %v\U0001F534", err)
+					updateLog.Error("Failed to parse the index file", logging.Phase, logging.PhaseInstall, "error", err)
+				}
+				updateLog = updateLog.With("available", data[service].Version)
+				downloadLog := updateLog.With(logging.Phase, logging.PhaseDownload)
+				installLog := updateLog.With(logging.Phase, logging.PhaseInstall)
+
+				record := func(e history.Entry) {
+					e.Version = data[service].Version
+					e.UpdateID = updateID
+					reporter.Record(e, updateLog)
 				}
+				source, user, requestTrace := requestedBy(autoApplied)
 
-				// getting service path
-				servicePath := data[service].Path
+				// the webhooks are notified of the outcome of the update attempt
+				notifyOutcome := func(e notify.Event) {
+					e.Version = data[service].Version
+					e.UpdateID = updateID
+					reporter.Notify(e, updateLog)
+				}
 
-				// download the artifact without specifying the file type
-				err = downloadArtifact(serviceAccountKeyPath, servicePath, newBinaryPath, generalLog)
+				// the spans of the update attempt are linked to the request asking for it, if it was traced
+				ctx, updateSpan := tracing.StartLinked(context.Background(), tracing.SpanUpdate, requestTrace,
+					tracing.AttrService.String(service),
+					tracing.AttrVersion.String(data[service].Version),
+					tracing.AttrUpdateID.String(updateID),
+					tracing.AttrSource.String(source),
+				)
+
+				// a version held back by the pin, the blocklist or the downgrade protection is never installed,
+				// even if requested, and neither is anything new while the metadata is expired
+				err = checkMetadataExpiry(data[service].Version)
+				if err == nil {
+					err = checkVersion(data[service])
+				}
 				if err != nil {
-					generalLog.Printf("\U0001F534Failed to download binary: %v\U0001F534\n", err)
-					os.Exit(1)
+					record(history.Entry{Event: history.EventRequest, Source: source, User: user, Outcome: history.OutcomeHeldBack, Error: err.Error()})
+					installLog.Warn("The update is not installed", "error", err)
+					if err := setUpdateFailed(err); err != nil {
+						installLog.Error("Failed to update update_status.json", "error", err)
+					}
+					tracing.End(updateSpan, err)
+					notifyOutcome(notify.Event{Type: notify.EventFailed, Error: err.Error()})
+					time.Sleep(time.Second * 5)
+					continue
 				}
 
-				// make sure the new binary is executable
-				err = os.Chmod(newBinaryPath, 0755)
+				// getting the path of the artifact built for this host
+				artifact, err := data[service].artifact()
 				if err != nil {
-					generalLog.Printf("Failed to set executable permissions \n")
+					record(history.Entry{Event: history.EventRequest, Source: source, User: user, Outcome: history.OutcomeFailure, Error: err.Error()})
+					installLog.Error("No artifact to install", "error", err)
+					if err := setUpdateFailed(err); err != nil {
+						installLog.Error("Failed to update update_status.json", "error", err)
+					}
+					tracing.End(updateSpan, err)
+					notifyOutcome(notify.Event{Type: notify.EventFailed, Error: err.Error()})
+					time.Sleep(time.Second * 5)
+					continue
 				}
-
-				// verifying that the downloaded file is integrate and authentic
-				err = verifyingDownloadedFile(targetIndexFile, newBinaryPath, generalLog)
-
-				if err == nil {
-					// Replace old binary
-					err = os.Rename(newBinaryPath, destinationPath)
+				serviceVersion := data[service].Version
+				// zero when the index has no valid release date
+				release, _ := policy.ParseReleaseDate(data[service].ReleaseDate)
+				record(history.Entry{Event: history.EventRequest, Source: source, User: user, Outcome: history.OutcomeSuccess})
+
+				// the version installed before, to tell an activation from a rollback
+				fromVersion := ""
+				if installed, err := installedVersion(); err == nil {
+					fromVersion = installed.String()
+				}
+				activation := activationEvent(fromVersion, serviceVersion)
+
+				// an artifact prefetched and verified in the background is installed as is. Otherwise the artifact
+				// is rebuilt from a delta and the installed artifact first, falling back to the full artifact
+				usePrefetched := isPrefetched(serviceVersion)
+				deltaApplied := false
+				if artifact.Delta != nil && !usePrefetched {
+					deltaStart := time.Now()
+					err := updateWithDelta(ctx, httpClient, artifact, downloadLog)
+					record(history.Entry{Event: history.EventDownload, Detail: "delta from " + artifact.Delta.From, Outcome: history.Outcome(err), Error: report.ErrorString(err), DurationMS: history.Since(deltaStart)})
 					if err != nil {
-						generalLog.Printf("Failed to rename the binary \n")
+						downloadLog.Warn("Delta update not applied, downloading the full artifact", "error", err)
+					} else {
+						deltaApplied = true
 					}
 				}
 
-				serviceVersion := data[service].Version
+				switch {
+				case deltaApplied:
+					installLog.Info("Installing the artifact rebuilt from the delta")
+				case usePrefetched:
+					installLog.Info("Installing the prefetched artifact")
+					record(history.Entry{Event: history.EventDownload, Detail: "prefetched", Outcome: history.OutcomeSuccess})
+				default:
+					// download and verify the artifact, retrying with backoff up to the maximum attempts of an update
+					downloadStart := time.Now()
+					err = retry.Do(ctx, downloadRetryPolicy, retryableDownload, func(err error, next time.Duration) {
+						downloadLog.Warn("Downloading the artifact failed", "error", err, "retryIn", next)
+					}, func() error {
+						return fetchArtifact(ctx, httpClient, artifact, nil, downloadLog)
+					})
+					record(history.Entry{Event: history.EventDownload, Outcome: history.Outcome(err), Error: report.ErrorString(err), DurationMS: history.Since(downloadStart)})
+					if err != nil {
+						downloadLog.Error("Failed to download the artifact", "error", err)
+						if err := setUpdateFailed(err); err != nil {
+							downloadLog.Error("Failed to update update_status.json", "error", err)
+						}
+						tracing.End(updateSpan, err)
+						notifyOutcome(notify.Event{Type: notify.EventFailed, Error: err.Error()})
+						time.Sleep(time.Second * 5)
+						continue
+					}
+				}
 
-				// unziping and setting the update status to 0
-				unzipAndSetStatus(serviceVersion, generalLog)
+				// Replace old binary, extract it into a staging directory and move it into place once validated.
+				// Nothing is switched if any of these steps fails.
+				err = os.Rename(newBinaryPath, destinationPath)
+				if err == nil {
+					err = installRelease(ctx, serviceVersion, release, extractArtifact, installLog)
+				}
+				if err != nil {
+					record(history.Entry{Event: activation, From: fromVersion, Source: source, User: user, Outcome: history.OutcomeFailure, Error: err.Error(), DurationMS: history.Since(updateStart)})
+					installLog.Error("Aborting the update", "error", err)
+					if err := setUpdateFailed(err); err != nil {
+						installLog.Error("Failed to update update_status.json", "error", err)
+					}
+					tracing.End(updateSpan, err)
+					notifyOutcome(notify.Event{Type: notify.EventFailed, Error: err.Error()})
+					time.Sleep(time.Second * 5)
+					continue
+				}
 
 				targetFileService := filepath.Join(SALTOLocation, serviceVersion, service)
 				targetFileConfig := filepath.Join(SALTOLocation, serviceVersion, "config", "general-service.yml")
 
+				// the symlinks are pointed back to their previous targets when the activation fails
+				previousTargets := readSymlinks(linkNameService, linkNameConfig)
+				failActivation := func(err error) {
+					record(history.Entry{Event: activation, From: fromVersion, Source: source, User: user, Outcome: history.OutcomeFailure, Error: err.Error(), DurationMS: history.Since(updateStart)})
+					if err := setUpdateFailed(err); err != nil {
+						installLog.Error("Failed to update update_status.json", "error", err)
+					}
+					if err := restoreSymlinks(previousTargets); err != nil {
+						installLog.Error("Failed to roll back the symlinks", "error", err)
+					} else {
+						installLog.Info("Symlinks rolled back")
+					}
+					tracing.End(updateSpan, err)
+					notifyOutcome(notify.Event{Type: notify.EventFailed, Error: err.Error()})
+				}
+
 				// 1) Updating symlink
+				_, activateSpan := tracing.Start(ctx, tracing.SpanActivate, tracing.AttrVersion.String(serviceVersion))
 
 				// symlink for service
 				if err := updateSymlink(targetFileService, linkNameService); err != nil {
-					generalLog.Printf("Error updating symlink:", err)
-					return
+					installLog.Error("Failed to update the symlink", "link", linkNameService, "error", err)
+					tracing.End(activateSpan, err)
+					failActivation(err)
+					time.Sleep(time.Second * 5)
+					continue
 				}
-				generalLog.Printf("Symlink updated to point to:", targetFileService)
+				installLog.Info("Symlink updated", "link", linkNameService, "target", targetFileService)
 
 				// symlink for config
 				if err := updateSymlink(targetFileConfig, linkNameConfig); err != nil {
-					generalLog.Printf("Error updating symlink:", err)
-					return
+					installLog.Error("Failed to update the symlink", "link", linkNameConfig, "error", err)
+					tracing.End(activateSpan, err)
+					failActivation(err)
+					time.Sleep(time.Second * 5)
+					continue
 				}
-				generalLog.Printf("Symlink updated to point to:", targetFileConfig)
+				installLog.Info("Symlink updated", "link", linkNameConfig, "target", targetFileConfig)
+				tracing.End(activateSpan, nil)
 
-				// 2) Reload and restart the service
-				ctx := context.Background()
-				if err := reloadAndRestartUnit(ctx, "general-service.service"); err != nil {
-					generalLog.Printf("Error restarting service:", err)
-					return
+				// 2) Reload and restart the service, then wait for it to become active
+				err = reloadAndRestartUnit(ctx, "general-service.service", installLog)
+				if err != nil {
+					installLog.Error("Failed to restart the service", "error", err)
+					failActivation(err)
+					// the previous version is started again from the restored symlinks
+					if err := reloadAndRestartUnit(ctx, "general-service.service", installLog); err != nil {
+						installLog.Error("Failed to restart the previous version", "error", err)
+					}
+					time.Sleep(time.Second * 5)
+					continue
 				}
+				installLog.Info("Service reloaded and restarted")
 
-				generalLog.Printf("Service reloaded and restarted successfully!")
+				err = checkUnitHealth(ctx, "general-service.service", healthCheckTimeout, installLog)
+				record(history.Entry{Event: activation, From: fromVersion, Source: source, User: user, Outcome: history.Outcome(err), Error: report.ErrorString(err), DurationMS: history.Since(updateStart)})
+				tracing.End(updateSpan, err)
+				notifyOutcome(notify.Event{Type: outcomeEvent(activation, err), From: fromVersion, Error: report.ErrorString(err)})
+				if err != nil {
+					// the previous version is kept to roll back to
+					installLog.Error("The service is not healthy after the update", "error", err)
+					if err := setUpdateFailed(err); err != nil {
+						installLog.Error("Failed to update update_status.json", "error", err)
+					}
+					time.Sleep(time.Second * 5)
+					continue
+				}
 
 				// Delete the previous version's folder
-
-				generalLog.Printf("🟣The previous version is %s🟣\n", previousVersion)
+				installLog.Info("Deleting the previous version folder", "previous", previousVersion)
 
 				previousVersionPath := filepath.Join(SALTOLocation, previousVersion)
 				err = os.RemoveAll(previousVersionPath)
-
-				generalLog.Printf("🟠Deleting previous version folder🟠\n")
 				if err != nil {
-					err = fmt.Errorf("error deleting the previous version's folder: %w", err)
-					generalLog.Printf("Error: %w \n", err) // Print the error or handle it appropriately
+					installLog.Error("Failed to delete the previous version folder", "previous", previousVersion, "error", err)
 				}
 
 				// The previus version is what has been stored in current version
 				previousVersion = currentVersion
 
-				generalLog.Printf("🟣The previous version is %s🟣\n", previousVersion)
-
 				currentVersion, err = readCurrentVersion()
-
-				generalLog.Printf("🟣Current Version is %s🟣\n", currentVersion)
-
 				if err != nil {
-					generalLog.Printf("Error reading the current version")
+					installLog.Error("Failed to read the current version", "error", err)
 				}
 
+				installLog.Info("Update installed", logging.Version, currentVersion, "previous", previousVersion)
+
 			}
 			time.Sleep(time.Second * 5)
 		}
@@ -311,7 +833,7 @@ func InitEnvironment() (string, error) {
 }
 
 // InitTrustOnFirstUse initialize local trusted metadata (Trust-On-First-Use)
-func InitTrustOnFirstUse(metadataDir string) error {
+func InitTrustOnFirstUse(client *http.Client, metadataDir string) error {
 	// check if there's already a local root.json available for bootstrapping trust
 	_, err := os.Stat(filepath.Join(metadataDir, "root.json"))
 	if err == nil {
@@ -329,8 +851,6 @@ func InitTrustOnFirstUse(metadataDir string) error {
 		return fmt.Errorf("failed to create http request: %w", err)
 	}
 
-	client := http.DefaultClient
-
 	res, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to executed the http request: %w", err)
@@ -377,12 +897,8 @@ func readCurrentVersion() (string, error) {
 
 // getPreviousVersion gets the previous running version of the service.
 // This will first read the folders that have version naming structure and the previous version will
-// be the one that is different from the currentVersion
+// be the newest one that is different from the currentVersion
 func getPreviousVersion(currentVersion string) (string, error) {
-	var previousVersion string
-
-	// Regular expression to match versioned folders
-	versionRegex := regexp.MustCompile(`^v\d{4}\.\d{2}\.\d{2}-sha\.[a-fA-F0-9]{7}$`)
 
 	// Read the directory
 	entries, err := os.ReadDir(SALTOLocation)
@@ -390,159 +906,512 @@ func getPreviousVersion(currentVersion string) (string, error) {
 		return "", fmt.Errorf("failed to read directory: %w", err)
 	}
 
-	var versions []string
+	var versions []version.Version
 
-	// Filter versioned folders
+	// Filter versioned folders, ordering the releases of the same day by their release date
 	for _, entry := range entries {
-		if entry.IsDir() && versionRegex.MatchString(entry.Name()) {
-			versions = append(versions, entry.Name())
+		if !entry.IsDir() || entry.Name() == currentVersion {
+			continue
 		}
+		v, err := version.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v.WithRelease(readReleaseMetadata(filepath.Join(SALTOLocation, entry.Name()))))
 	}
 
-	// Ensure we have exactly two versions
-	if len(versions) != 2 {
-		return "", fmt.Errorf("expected 2 versioned folders, found %d", len(versions))
+	if len(versions) == 0 {
+		return "", fmt.Errorf("previous version not found")
 	}
 
-	// Identify the previous version (the one different from currentVersion)
-	for _, version := range versions {
-		if version != currentVersion {
-			previousVersion = version
-			break
-		}
+	version.Sort(versions)
+
+	return versions[len(versions)-1].String(), nil
+}
+
+// DownloadTargetIndex downloads the target index file of the release channel using the long-lived TUF
+// client. The client refreshes the top-level metadata when it is due, gets the target information,
+// verifies that it is signed by the role expected for the channel, verifies if the target is already
+// cached, and in case it is not cached, downloads the target file.
+func DownloadTargetIndex(tufClient *tufclient.Client, service, releaseChannel string) ([]byte, int, error) {
+
+	serviceFilePath := channel.IndexPath(service, releaseChannel)
+
+	// Decode serviceFilePath before looking for the target
+	decodedServiceFilePath, _ := url.QueryUnescape(serviceFilePath)
+
+	targetFilePath := filepath.Join(SALTOLocation, "data", service, fmt.Sprintf("%s-index.json", service))
+
+	// Ensure it is unescaped
+	decodedTargetFilePath, _ := url.QueryUnescape(targetFilePath)
+
+	tb, cached, err := tufClient.DownloadDelegatedTarget(decodedServiceFilePath, decodedTargetFilePath, channelRoles.For(releaseChannel))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download target index file %s: %w", service, err)
 	}
 
-	if previousVersion == "" {
-		return "", fmt.Errorf("previous version not found")
+	if cached {
+		// Cached version found
+		slog.Debug("Target index cached", logging.Phase, logging.PhaseCheck, "path", decodedTargetFilePath)
+		return tb, 1, nil
 	}
 
-	return previousVersion, nil
-}
+	slog.Debug("Target index downloaded", logging.Phase, logging.PhaseCheck, "path", decodedTargetFilePath)
 
-// DownloadTargetIndex downloads the target file using Updater. The Updater refreshes the top-level metadata,
-// get the target information, verifies if the target is already cached, and in case it
-// is not cached, downloads the target file.
-func DownloadTargetIndex(localMetadataDir, service string) ([]byte, int, error) {
+	return tb, 0, nil
+}
 
-	serviceFilePath := filepath.Join(service, fmt.Sprintf("%s-index.json", service))
+// logTUFError logs a TUF error making clear whether it will be retried on the next check or it
+// needs the attention of an operator.
+func logTUFError(logger *slog.Logger, err error, msg string, args ...any) {
+	if tufclient.Retryable(err) {
+		logger.Warn(msg, append(args, "error", err, "action", "retrying")...)
+		return
+	}
+	logger.Error(msg, append(args, "error", err, "action", "alarm")...)
+}
 
-	rootBytes, err := os.ReadFile(filepath.Join(localMetadataDir, "root.json"))
+// requestedBy returns who requested the pending update, as recorded in update_status.json, or the update
+// policy when it was autoApplied, and the traceparent of the request if it was traced.
+func requestedBy(autoApplied bool) (source, user, traceparent string) {
+	if autoApplied {
+		return history.SourcePolicy, "", ""
+	}
+	s, err := status.Read(jsonFilePath)
 	if err != nil {
-		return nil, 0, err
+		return "", "", ""
 	}
+	return s.RequestSource, s.RequestUser, s.RequestTrace
+}
 
-	// create updater configuration
-	cfg, err := config.New(metadataURL, rootBytes) // default config
+// activationEvent returns EventRollback when serviceVersion is older than the installed one, and
+// EventActivate otherwise.
+func activationEvent(installed, serviceVersion string) string {
+	from, err := version.Parse(installed)
 	if err != nil {
-		return nil, 0, err
+		return history.EventActivate
 	}
+	to, err := version.Parse(serviceVersion)
+	if err != nil || !to.Before(from) {
+		return history.EventActivate
+	}
+	return history.EventRollback
+}
+
+// outcomeEvent returns the event notified for an update activating a version, or rolling back to it, that
+// ended with err.
+func outcomeEvent(activation string, err error) string {
+	switch {
+	case err != nil:
+		return notify.EventFailed
+	case activation == history.EventRollback:
+		return notify.EventRolledBack
+	default:
+		return notify.EventApplied
+	}
+}
+
+// Function to update update_status.json. Any pending update request and the error of the previous
+// update are cleared.
+func setUpdateStatus(value int) error {
+	return status.Update(jsonFilePath, func(s *status.Status) {
+		s.UpdateAvailable = value
+		s.UpdateRequested = 0
+		s.RequestSource = ""
+		s.RequestUser = ""
+		s.RequestTrace = ""
+		s.LastError = ""
+		s.Prefetched = ""
+		s.RolloutPending = false
+		s.HeldBack = ""
+		if value == 0 {
+			s.NextAutoApply = nil
+			s.Rollback = false
+			s.ExpiredApproved = ""
+		}
+	})
+}
 
-	cfg.LocalMetadataDir = localMetadataDir
-	cfg.LocalTargetsDir = filepath.Join(SALTOLocation, "data")
-	cfg.RemoteTargetsURL = targetsURL
-	cfg.PrefixTargetsWithHash = true
+// updatePending reports whether there is an update offered to this host or held back from it.
+func updatePending() bool {
+	s, err := status.Read(jsonFilePath)
+	return err == nil && (s.UpdateAvailable == 1 || s.RolloutPending || s.HeldBack != "")
+}
 
-	// create a new Updater instance
-	up, err := updater.New(cfg)
+// offerUpdate decides whether the update described by index is offered to this host. It is held back
+// while its version is blocklisted, outside the pin or older than the installed one, and while its staged
+// rollout has not reached this host. An index that cannot be parsed is not held back, the update will fail later when verifying it.
+func offerUpdate(index []byte, now time.Time, generalLog *slog.Logger) error {
+	var data map[string]indexInfo
+	if err := json.Unmarshal(index, &data); err != nil {
+		generalLog.Error("Failed to parse the index", "error", err)
+	}
+	info := data[service]
+
+	return reporter.Offer(report.Offer{
+		Version:   info.Version,
+		Rollout:   info.Rollout,
+		Pin:       pinVersion,
+		Blocklist: blockedVersion,
+		Hold: func(s status.Status, rules pin.Rules) error {
+			return checkCandidate(s, rules, info)
+		},
+	}, now, generalLog)
+}
+
+// checkVersion returns an error if the pin or the blocklist in force forbid installing the update
+// described by info, if it would downgrade the service without a rollback being requested, or if
+// its index cannot be used by this host: unsupported schema or no artifact for this platform.
+func checkVersion(info indexInfo) error {
+	s, err := status.Read(jsonFilePath)
+	if err != nil {
+		return err
+	}
+	rules, err := report.Rules(s, pinVersion, blockedVersion)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create Updater instance: %w", err)
+		return err
 	}
+	return checkCandidate(s, rules, info)
+}
 
-	// try to build the top-level metadata
-	err = up.Refresh()
+// checkCandidate is checkVersion with the status and the rules already read.
+func checkCandidate(s status.Status, rules pin.Rules, info indexInfo) error {
+	if _, err := info.artifact(); err != nil {
+		return err
+	}
+	if err := info.Rollout.Validate(); err != nil {
+		return err
+	}
+	if err := rules.Check(info.Version); err != nil {
+		return err
+	}
+
+	candidate, err := version.Parse(info.Version)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to refresh trusted metadata: %w", err)
+		return err
+	}
+	if release, err := policy.ParseReleaseDate(info.ReleaseDate); err == nil {
+		candidate = candidate.WithRelease(release)
 	}
 
-	// Decode serviceFilePath before calling GetTargetInfo
-	decodedServiceFilePath, _ := url.QueryUnescape(serviceFilePath)
+	// without a known installed version there is nothing to downgrade from
+	installed, err := installedVersion()
+	if err != nil {
+		return nil
+	}
+	return version.CheckUpgrade(installed, candidate, s.Rollback)
+}
 
-	// Get metadata info
-	ti, err := up.GetTargetInfo(decodedServiceFilePath)
+// installedVersion returns the version the service symlink points to, ordered among the releases of
+// the same day by the release date recorded when it was installed.
+func installedVersion() (version.Version, error) {
+	target, err := filepath.EvalSymlinks(linkNameService)
+	if err != nil {
+		return version.Version{}, err
+	}
+	dir := filepath.Dir(target)
+	v, err := version.Parse(filepath.Base(dir))
 	if err != nil {
-		return nil, 0, fmt.Errorf("getting info for target index \"%s\": %w", serviceFilePath, err)
+		return version.Version{}, err
 	}
+	return v.WithRelease(readReleaseMetadata(dir)), nil
+}
 
-	os.Mkdir(filepath.Join(SALTOLocation, "data", service), 0750)
+// releaseMetadata is the content of the releaseMetadataFile of a version directory.
+type releaseMetadata struct {
+	ReleaseDate time.Time `json:"release_date"`
+}
 
-	targetFilePath := filepath.Join(SALTOLocation, "data", service, fmt.Sprintf("%s-index.json", service))
-	os.MkdirAll(filepath.Dir(targetFilePath), 0750) // Ensure the directory exists
+// writeReleaseMetadata records the release date of the release extracted in dir. Nothing is written when
+// the index has no valid release date.
+func writeReleaseMetadata(dir string, release time.Time) error {
+	if release.IsZero() {
+		return nil
+	}
+	data, err := json.Marshal(releaseMetadata{ReleaseDate: release})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, releaseMetadataFile), data, 0644)
+}
 
-	path, tb, err := up.FindCachedTarget(ti, targetFilePath)
+// readReleaseMetadata returns the release date recorded in the version directory dir, or the zero time
+// when unknown, e.g. for the versions installed before it was recorded.
+func readReleaseMetadata(dir string) time.Time {
+	var m releaseMetadata
+	data, err := os.ReadFile(filepath.Join(dir, releaseMetadataFile))
+	if err != nil || json.Unmarshal(data, &m) != nil {
+		return time.Time{}
+	}
+	return m.ReleaseDate
+}
+
+// setUpdateFailed clears the update request and records why it failed, so that it can be shown
+// to the user and requested again.
+func setUpdateFailed(updateErr error) error {
+	return status.Update(jsonFilePath, func(s *status.Status) {
+		s.UpdateRequested = 0
+		s.RequestSource = ""
+		s.RequestUser = ""
+		s.RequestTrace = ""
+		s.LastError = updateErr.Error()
+	})
+}
 
+// checkMetadataExpiry returns an error wrapping errMetadataExpired if the trusted metadata is expired and
+// serviceVersion was not approved by an operator importing a bundle.
+func checkMetadataExpiry(serviceVersion string) error {
+	s, err := status.Read(jsonFilePath)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to find if there is a cachet target: %w", err)
+		return err
+	}
+	if s.MetadataExpired == nil || s.ExpiredApproved == serviceVersion {
+		return nil
 	}
+	return fmt.Errorf("%w since %s, import a bundle to install updates", errMetadataExpired, s.MetadataExpired.Format(time.RFC3339))
+}
 
-	if path != "" {
-		// Cached version found
-		fmt.Println("\U0001F34C CACHE HIT")
-		return tb, 1, nil
+// ReadUpdateRequested extracts the "update_requested" value from a JSON file
+func ReadUpdateRequested(jsonFilePath string) (int, error) {
+	s, err := status.Read(jsonFilePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read update status: %w", err)
 	}
 
-	// Ensure it is unescaped
-	decodedTargetFilePath, _ := url.QueryUnescape(targetFilePath)
+	return s.UpdateRequested, nil
+}
 
-	// Now download
-	targetfilePath, tb, err := up.DownloadTarget(ti, decodedTargetFilePath, "")
+// evaluateUpdatePolicy reports whether the available update must be applied now according to the update
+// policy of the service, and stores in update_status.json when it will be applied automatically.
+func evaluateUpdatePolicy(now time.Time) (bool, error) {
+	s, err := status.Read(jsonFilePath)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to download target index file %s - %w", service, err)
+		return false, err
 	}
 
-	fmt.Printf(" 🎯📄The target File Path is: %s 🎯📄", targetfilePath)
+	p := updatePolicies.For(service)
 
-	return tb, 0, nil
+	// an update that failed is not applied automatically again, the user has to request it
+	var next *time.Time
+	if s.UpdateAvailable == 1 && s.LastError == "" {
+		release, err := readReleaseDate()
+		if err != nil {
+			return false, err
+		}
+		if t, ok := p.NextApply(release, now); ok {
+			next = &t
+		}
+	}
+
+	// only write the status when the schedule changes
+	if s.UpdatePolicy != p.String() || !sameTime(s.NextAutoApply, next) {
+		err = status.Update(jsonFilePath, func(s *status.Status) {
+			s.UpdatePolicy = p.String()
+			s.NextAutoApply = next
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return next != nil && !next.After(now), nil
 }
 
-// Function to update update_status.json
-func setUpdateStatus(value int) error {
-	// Create struct with new value
-	updateStatus := UpdateStatus{UpdateAvailable: value}
+// readReleaseDate returns the release date of the available update. When the index does not have a
+// valid one, the time the index was downloaded is used.
+func readReleaseDate() (time.Time, error) {
+	var data map[string]indexInfo
 
-	// Convert struct to JSON
-	file, err := json.MarshalIndent(updateStatus, "", "  ")
+	fileContent, err := os.ReadFile(targetIndexFile)
 	if err != nil {
-		return err
+		return time.Time{}, fmt.Errorf("failed to read index file: %w", err)
+	}
+	if err := json.Unmarshal(fileContent, &data); err != nil {
+		return time.Time{}, fmt.Errorf("error parsing the JSON: %w", err)
+	}
+
+	if release, err := policy.ParseReleaseDate(data[service].ReleaseDate); err == nil {
+		return release, nil
 	}
 
-	// Write JSON to file
-	err = os.WriteFile(jsonFilePath, file, 0644)
+	info, err := os.Stat(targetIndexFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// readCheckRequested reports whether an immediate update check has been requested, clearing the request.
+func readCheckRequested(jsonFilePath string) (bool, error) {
+	s, err := status.Read(jsonFilePath)
+	if err != nil || s.CheckRequested == 0 {
+		return false, err
+	}
+
+	err = status.Update(jsonFilePath, func(s *status.Status) {
+		s.CheckRequested = 0
+	})
+	return true, err
+}
+
+// fetchArtifact downloads the artifact and verifies it against the index. Every failure is returned so
+// that the caller can retry it. The peers are tried before the origin, and the verified artifact is shared
+// with them afterwards.
+func fetchArtifact(ctx context.Context, client *http.Client, artifact index.Artifact, limiter *ratelimit.Limiter, generalLog *slog.Logger) error {
+	fromPeer := false
+	if peers != nil {
+		// the peers are not trusted: only content matching the size and the hash signed in TUF is accepted
+		_, span := tracing.Start(ctx, tracing.SpanArtifactDownload, tracing.AttrPeer.Bool(true))
+		length, err := artifact.Length()
+		if err == nil {
+			err = peers.Fetch(ctx, artifact.Hashes.Sha256, length, newBinaryPath)
+		}
+		if err != nil {
+			generalLog.Info("The artifact could not be fetched from the peers", "error", err)
+		} else {
+			generalLog.Info("Artifact fetched from a peer")
+			fromPeer = true
+			if info, err := os.Stat(newBinaryPath); err == nil {
+				span.SetAttributes(tracing.AttrBytes.Int64(info.Size()))
+			}
+		}
+		tracing.End(span, err)
+	}
+
+	// download the artifact without specifying the file type
+	if !fromPeer {
+		// the size signed in TUF bounds the download, unless the index does not have it
+		length, _ := artifact.Length()
+		if err := downloadArtifact(ctx, client, serviceAccountKeyPath, artifact.Path, newBinaryPath, length, limiter, generalLog); err != nil {
+			return err
+		}
+	}
+
+	// make sure the new binary is executable
+	if err := os.Chmod(newBinaryPath, 0755); err != nil {
+		generalLog.Warn("Failed to set executable permissions", "error", err)
+	}
+
+	// verifying that the downloaded file is integrate and authentic
+	_, verifySpan := tracing.Start(ctx, tracing.SpanVerify)
+	err := verifyingDownloadedFile(targetIndexFile, newBinaryPath, generalLog)
+	tracing.End(verifySpan, err)
 	if err != nil {
 		return err
 	}
+
+	if peers != nil {
+		if err := peers.Store(newBinaryPath, artifact.Hashes.Sha256); err != nil {
+			generalLog.Warn("The artifact could not be shared with the peers", "error", err)
+		}
+	}
 	return nil
 }
 
-// ReadUpdateRequested extracts the "update_requested" value from a JSON file
-func ReadUpdateRequested(jsonFilePath string) (int, error) {
-	// Read the JSON file content
-	fileContent, err := os.ReadFile(jsonFilePath)
+// prefetchUpdate downloads and verifies the artifact of the available update unless it was already
+// attempted, and records it in update_status.json as ready to install. It returns the version attempted.
+// Updates held back, failed or installed from a delta are not prefetched, nor anything while the metadata is
+// expired.
+func prefetchUpdate(client *http.Client, attempted string, generalLog *slog.Logger) (string, error) {
+	s, err := status.Read(jsonFilePath)
+	if err != nil {
+		return "", err
+	}
+	if s.UpdateAvailable != 1 || s.LastError != "" || s.Prefetched != "" || s.MetadataExpired != nil {
+		return "", nil
+	}
+
+	var data map[string]indexInfo
+	fileContent, err := os.ReadFile(targetIndexFile)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read JSON file: %v", err)
+		return "", fmt.Errorf("failed to read index file: %w", err)
+	}
+	if err := json.Unmarshal(fileContent, &data); err != nil {
+		return "", fmt.Errorf("error parsing the JSON: %w", err)
+	}
+	info := data[service]
+	if info.Version == attempted || checkVersion(info) != nil {
+		return "", nil
 	}
 
-	// Unmarshal JSON into struct
-	var status UpdateStatus
-	err = json.Unmarshal(fileContent, &status)
+	artifact, err := info.artifact()
 	if err != nil {
-		return 0, fmt.Errorf("error parsing JSON: %v", err)
+		return info.Version, err
+	}
+	if artifact.Delta != nil {
+		if installed, err := installedVersion(); err == nil && installed.String() == artifact.Delta.From {
+			return info.Version, nil
+		}
+	}
+
+	generalLog = generalLog.With("available", info.Version)
+	generalLog.Info("Prefetching the artifact")
+	start := time.Now()
+	err = fetchArtifact(context.Background(), client, artifact, ratelimit.New(prefetchRate), generalLog)
+	reporter.Record(history.Entry{
+		Event:      history.EventDownload,
+		Version:    info.Version,
+		Detail:     "prefetch",
+		Outcome:    history.Outcome(err),
+		Error:      report.ErrorString(err),
+		DurationMS: history.Since(start),
+	}, generalLog)
+	if err != nil {
+		return info.Version, err
 	}
 
-	return status.UpdateRequested, nil
+	err = status.Update(jsonFilePath, func(s *status.Status) {
+		s.Prefetched = info.Version
+	})
+	if err != nil {
+		return info.Version, err
+	}
+	generalLog.Info("The update is ready to install")
+	return info.Version, nil
+}
+
+// isPrefetched reports whether the artifact of serviceVersion has been prefetched and still matches
+// the index.
+func isPrefetched(serviceVersion string) bool {
+	s, err := status.Read(jsonFilePath)
+	if err != nil || s.Prefetched != serviceVersion {
+		return false
+	}
+	return verifyingDownloadedFile(targetIndexFile, newBinaryPath, slog.New(slog.NewTextHandler(io.Discard, nil))) == nil
 }
 
-// Downloading the artifact indicated in general-service.json
-func downloadArtifact(serviceAccountKeyPath, servicePath, newBinaryPath string, generalLog *log.Logger) error {
-	// Authenticate using the service account key
-	ctx := context.Background()
-	creds, err := google.CredentialsFromJSON(ctx, readFile(serviceAccountKeyPath, generalLog), "https://www.googleapis.com/auth/cloud-platform")
+// retryableDownload tells which artifact download errors are worth retrying. Besides network errors,
+// a hash mismatch is retried as it is usually caused by a truncated download.
+func retryableDownload(err error) bool {
+	return httpclient.Retryable(err) || errors.Is(err, errHashMismatch)
+}
+
+// Downloading the artifact indicated in general-service.json. No more than length bytes are accepted, when
+// it is known.
+func downloadArtifact(ctx context.Context, client *http.Client, serviceAccountKeyPath, servicePath, newBinaryPath string, length int64, limiter *ratelimit.Limiter, generalLog *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, tracing.SpanArtifactDownload)
+	defer func() { tracing.End(span, err) }()
+
+	// Authenticate using the service account key, fetching the token through the same client
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	key, err := os.ReadFile(serviceAccountKeyPath)
+	if err != nil {
+		return fmt.Errorf("failed to read service account key: %w", err)
+	}
+	creds, err := google.CredentialsFromJSON(ctx, key, "https://www.googleapis.com/auth/cloud-platform")
 	if err != nil {
 		return fmt.Errorf("failed to load service account credentials: %w", err)
 	}
 
-	// Create HTTP client with the token
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", servicePath, nil)
+	// Create the request with the token
+	req, err := http.NewRequestWithContext(ctx, "GET", servicePath, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -562,7 +1431,7 @@ func downloadArtifact(serviceAccountKeyPath, servicePath, newBinaryPath string,
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to download artifact, status code: %d", resp.StatusCode)
+		return &httpclient.StatusError{URL: servicePath, StatusCode: resp.StatusCode}
 	}
 
 	// Determine the file name from the Content-Disposition header or use a default name
@@ -576,7 +1445,7 @@ func downloadArtifact(serviceAccountKeyPath, servicePath, newBinaryPath string,
 			}
 		}
 	}
-	generalLog.Printf("Saving file as: %s\n", fileName)
+	generalLog.Debug("Saving the download", "path", fileName)
 
 	// Write the response to a file
 	out, err := os.Create(fileName)
@@ -585,22 +1454,26 @@ func downloadArtifact(serviceAccountKeyPath, servicePath, newBinaryPath string,
 	}
 	defer out.Close()
 
-	_, err = io.Copy(out, resp.Body)
-	return err
-}
+	// report the progress every few seconds together with the effective rate limit
+	body := ratelimit.Progress(limiter.Reader(ctx, resp.Body), resp.ContentLength, progressInterval, func(r ratelimit.Report) {
+		generalLog.Info("Download progress", "progress", r.String())
+	}, limiter, downloadLimiter)
 
-// readFile reads the content of the service account key JSON file.
-func readFile(path string, generalLog *log.Logger) []byte {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		generalLog.Printf("\U0001F534Error reading file %s: %v\U0001F534\n", path, err)
-		os.Exit(1)
+	if length <= 0 {
+		n, err := io.Copy(out, body)
+		span.SetAttributes(tracing.AttrBytes.Int64(n))
+		return err
+	}
+	n, err := io.Copy(out, io.LimitReader(body, length+1))
+	span.SetAttributes(tracing.AttrBytes.Int64(n))
+	if err == nil && n > length {
+		err = fmt.Errorf("%s is larger than the %d bytes signed", servicePath, length)
 	}
-	return content
+	return err
 }
 
 // verifyingDownloadedFile verifies a file.
-func verifyingDownloadedFile(targetIndexFile, DonwloadedFilePath string, generalLog *log.Logger) error {
+func verifyingDownloadedFile(targetIndexFile, DonwloadedFilePath string, generalLog *slog.Logger) error {
 
 	var data map[string]indexInfo
 
@@ -613,30 +1486,31 @@ func verifyingDownloadedFile(targetIndexFile, DonwloadedFilePath string, general
 	// Parse JSON into the map
 	err = json.Unmarshal(fileContent, &data)
 	if err != nil {
-		generalLog.Printf("\U0001F534Error parsing JSON: %v\U0001F534", err)
+		generalLog.Error("Failed to parse the index", "error", err)
 		return err
 	}
 
-	indexHash := data[service].Hashes.Sha256
-
-	generalLog.Printf("The hash from the nebula-service-index.json is %s", indexHash)
+	artifact, err := data[service].artifact()
+	if err != nil {
+		return err
+	}
+	indexHash := artifact.Hashes.Sha256
 
 	// Computing the hash of the downloaded file
 
 	// Compute the SHA256 hash
 	downloadedFilehash, err := ComputeSHA256(DonwloadedFilePath)
-
-	generalLog.Printf("Downloaded file hash is: %s\n", downloadedFilehash)
-
 	if err != nil {
-		generalLog.Printf("\U0001F534Error computing hash: %v\U0001F534\n", err)
+		generalLog.Error("Failed to compute the hash", "path", DonwloadedFilePath, "error", err)
 		return fmt.Errorf("error while computing the hash")
 	}
 
+	generalLog.Debug("Verifying the downloaded file", "expected", indexHash, "actual", downloadedFilehash)
+
 	if indexHash == downloadedFilehash {
-		generalLog.Printf("\U0001F7E2The target file has been downloaded successfully!\U0001F7E2\n")
+		generalLog.Info("The artifact has been downloaded and verified")
 	} else {
-		return fmt.Errorf("there has been an error while downloading the file, the hashes do not match")
+		return fmt.Errorf("there has been an error while downloading the file: %w", errHashMismatch)
 	}
 	return nil
 }
@@ -664,93 +1538,180 @@ func ComputeSHA256(filePath string) (string, error) {
 	return fmt.Sprintf("%x", hash), nil
 }
 
-// Unzipping the downloaded target and setting the update status to 0.
-func unzipAndSetStatus(serviceVersion string, generalLog *log.Logger) {
+// installRelease builds the release into a staging directory with extract, validates it, records its
+// release date and only then renames it to SALTOLocation/<version> and sets the update status to 0. On error, the staging directory
+// is removed and the installed versions are left untouched.
+func installRelease(ctx context.Context, serviceVersion string, release time.Time, extract func(stagingPath string) error, generalLog *slog.Logger) error {
 
-	destinationPathUnzip := ""
-	destinationPathUnzip = fmt.Sprintf("%s/%s", SALTOLocation, serviceVersion)
+	if !version.IsTag(serviceVersion) {
+		return fmt.Errorf("invalid version %q in the index", serviceVersion)
+	}
 
-	// Unzipping the downloaded target
-	if err := Unzip(destinationPath, destinationPathUnzip); err != nil {
-		generalLog.Printf("❌ Error unzipping new binary: %v", err)
-	} else {
-		generalLog.Printf("✅ Successfully unzipped the new binary.")
+	stagingPath, err := os.MkdirTemp(SALTOLocation, ".staging-"+serviceVersion+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create the staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingPath)
+
+	_, extractSpan := tracing.Start(ctx, tracing.SpanExtract, tracing.AttrVersion.String(serviceVersion))
+	err = extract(stagingPath)
+	if err == nil {
+		generalLog.Info("Release extracted", "staging", stagingPath)
+		if err = validateRelease(stagingPath); err != nil {
+			err = fmt.Errorf("invalid release %s: %w", serviceVersion, err)
+		} else {
+			err = writeReleaseMetadata(stagingPath, release)
+		}
+	}
+	tracing.End(extractSpan, err)
+	if err != nil {
+		return err
+	}
+
+	// MkdirTemp creates the directory with mode 0700
+	if err := os.Chmod(stagingPath, 0755); err != nil {
+		return err
 	}
 
-	// Removing what has been unzipped
-	os.Remove(destinationPath)
+	// a version directory already there, e.g. when installing the same version again, is moved aside
+	// first as rename does not replace non-empty directories
+	versionPath := filepath.Join(SALTOLocation, serviceVersion)
+	if _, err := os.Lstat(versionPath); err == nil {
+		asidePath := stagingPath + ".old"
+		if err := os.Rename(versionPath, asidePath); err != nil {
+			return fmt.Errorf("failed to move the existing %s aside: %w", serviceVersion, err)
+		}
+		defer os.RemoveAll(asidePath)
+	}
+
+	if err := os.Rename(stagingPath, versionPath); err != nil {
+		return fmt.Errorf("failed to move the release into place: %w", err)
+	}
 
 	// Setting update status to 0
-	setUpdateStatus(0)
+	return setUpdateStatus(0)
+}
+
+// extractArtifact extracts the downloaded full artifact into stagingPath.
+func extractArtifact(stagingPath string) error {
+
+	// Removing what has been unzipped once done, whatever the result
+	defer os.Remove(destinationPath)
 
+	// Unzipping the downloaded target
+	if err := Unzip(destinationPath, stagingPath); err != nil {
+		return fmt.Errorf("error unzipping new binary: %w", err)
+	}
+	return nil
 }
 
-// Unzipping a .zip and relocating it.
-func Unzip(src, dest string) error {
-	r, err := zip.OpenReader(src)
+// updateWithDelta rebuilds the artifact at newBinaryPath from its delta and the artifact of the installed
+// version, when it is the one the delta was built from. The delta is bounded and verified against its size and
+// hash, and the rebuilt artifact against the ones of the full artifact. The full artifact is downloaded instead
+// on any error.
+func updateWithDelta(ctx context.Context, client *http.Client, artifact index.Artifact, generalLog *slog.Logger) error {
+	d := artifact.Delta
+	installed, err := installedVersion()
+	if err != nil {
+		return fmt.Errorf("installed version unknown: %w", err)
+	}
+	if installed.String() != d.From {
+		return fmt.Errorf("delta built from %s, installed version is %s", d.From, installed)
+	}
+	deltaLength, err := d.Length()
+	if err != nil {
+		return err
+	}
+	length, err := artifact.Length()
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := r.Close(); err != nil {
-			panic(err)
-		}
-	}()
-
-	os.MkdirAll(dest, 0755)
 
-	// Closure to address file descriptors issue with all the deferred .Close() methods
-	extractAndWriteFile := func(f *zip.File) error {
-		rc, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if err := rc.Close(); err != nil {
-				panic(err)
-			}
-		}()
+	defer os.Remove(deltaPath)
+	if err := downloadArtifact(ctx, client, serviceAccountKeyPath, d.Path, deltaPath, deltaLength, nil, generalLog); err != nil {
+		return err
+	}
+	_, verifySpan := tracing.Start(ctx, tracing.SpanVerify, tracing.AttrFrom.String(d.From))
+	deltaHash, err := ComputeSHA256(deltaPath)
+	if err == nil && !strings.EqualFold(deltaHash, d.Hashes.Sha256) {
+		err = fmt.Errorf("%w: delta hash %s, expected %s", errHashMismatch, deltaHash, d.Hashes.Sha256)
+	}
+	if err == nil {
+		// the artifact of the installed version is the one last moved to destinationPath
+		err = delta.Apply(deltaPath, destinationPath, newBinaryPath, length, artifact.Hashes.Sha256)
+	}
+	tracing.End(verifySpan, err)
+	if err != nil {
+		return err
+	}
+	generalLog.Info("Artifact rebuilt from the delta and verified", "from", d.From)
+	return nil
+}
 
-		path := filepath.Join(dest, f.Name)
+// validateRelease checks that an extracted release holds the service binary, built for this platform,
+// and its configuration.
+func validateRelease(dir string) error {
+	binaryPath := filepath.Join(dir, service)
+	info, err := os.Stat(binaryPath)
+	if err != nil {
+		return fmt.Errorf("missing binary: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return fmt.Errorf("%s is not an executable file", service)
+	}
+	if err := checkELF(binaryPath); err != nil {
+		return err
+	}
 
-		// Check for ZipSlip (Directory traversal)
-		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return fmt.Errorf("illegal file path: %s", path)
-		}
+	info, err = os.Stat(filepath.Join(dir, "config", "general-service.yml"))
+	if err != nil {
+		return fmt.Errorf("missing configuration: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("config/general-service.yml is not a regular file")
+	}
+	return nil
+}
 
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(path, f.Mode())
-		} else {
-			os.MkdirAll(filepath.Dir(path), f.Mode())
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					panic(err)
-				}
-			}()
+// elfMachines maps GOARCH to the ELF machine of the binaries built for it.
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"ppc64le": elf.EM_PPC64,
+	"riscv64": elf.EM_RISCV,
+	"s390x":   elf.EM_S390,
+}
 
-			_, err = io.Copy(f, rc)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+// checkELF checks that path is an ELF executable for the architecture of this host.
+func checkELF(path string) error {
+	f, err := elf.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s is not an ELF binary: %w", filepath.Base(path), err)
 	}
+	defer f.Close()
 
-	for _, f := range r.File {
-		err := extractAndWriteFile(f)
-		if err != nil {
-			return err
-		}
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return fmt.Errorf("%s is not an executable, ELF type %s", filepath.Base(path), f.Type)
+	}
+	if machine, ok := elfMachines[runtime.GOARCH]; ok && f.Machine != machine {
+		return fmt.Errorf("%s is built for %s, this host is %s", filepath.Base(path), f.Machine, runtime.GOARCH)
 	}
 	return nil
 }
 
+// Unzip extracts the downloaded artifact into dest. Despite its name, zip, tar, tar.gz and tar.zst
+// artifacts are accepted, the format is detected from the content.
+func Unzip(src, dest string) error {
+	return archive.Extract(src, dest, archive.DefaultLimits)
+}
+
 // It reloads and restarts the unit
-func reloadAndRestartUnit(ctx context.Context, unitName string) error {
+func reloadAndRestartUnit(ctx context.Context, unitName string, generalLog *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, tracing.SpanRestart, tracing.AttrUnit.String(unitName))
+	defer func() { tracing.End(span, err) }()
+
 	// Connect to systemd via D-Bus using the context-aware method
 	conn, err := dbus.NewSystemConnectionContext(ctx)
 	if err != nil {
@@ -769,10 +1730,75 @@ func reloadAndRestartUnit(ctx context.Context, unitName string) error {
 		return fmt.Errorf("failed to restart unit %s: %w", unitName, err)
 	}
 
-	fmt.Printf("Restart job queued: %v\n", jobID)
+	generalLog.Info("Restart job queued", "unit", unitName, "job", jobID)
 	return nil
 }
 
+// checkUnitHealth waits up to timeout for the restarted unit to become active. It fails as soon as the unit
+// fails, or when it is still not active once the timeout is over.
+func checkUnitHealth(ctx context.Context, unitName string, timeout time.Duration, generalLog *slog.Logger) (err error) {
+	ctx, span := tracing.Start(ctx, tracing.SpanHealthCheck, tracing.AttrUnit.String(unitName))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := dbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to system bus: %w", err)
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	state := ""
+	for {
+		prop, err := conn.GetUnitPropertyContext(ctx, unitName, "ActiveState")
+		if err == nil {
+			state, _ = prop.Value.Value().(string)
+		}
+		switch state {
+		case "active":
+			generalLog.Info("Service active", "unit", unitName)
+			return nil
+		case "failed":
+			return fmt.Errorf("unit %s failed after the restart", unitName)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unit %s not active after %s, state %q", unitName, timeout, state)
+		case <-ticker.C:
+		}
+	}
+}
+
+// readSymlinks returns the targets of the symlinks, empty for the ones that cannot be read.
+func readSymlinks(linkNames ...string) map[string]string {
+	targets := make(map[string]string, len(linkNames))
+	for _, linkName := range linkNames {
+		targets[linkName], _ = os.Readlink(linkName)
+	}
+	return targets
+}
+
+// restoreSymlinks points the symlinks back to the targets returned by readSymlinks, removing the ones
+// that had none.
+func restoreSymlinks(targets map[string]string) error {
+	var errs []error
+	for linkName, target := range targets {
+		if target == "" {
+			if err := os.Remove(linkName); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to remove symlink: %w", err))
+			}
+			continue
+		}
+		if err := updateSymlink(target, linkName); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // updateSymlink updates the symlink
 func updateSymlink(newTarget, linkName string) error {
 	if err := os.Remove(linkName); err != nil && !os.IsNotExist(err) {
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sorayaormazabalmayo/general-service/internal/status"
+	"github.com/sorayaormazabalmayo/general-service/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const testVersion = "v2025.03.01-sha.1a2b3c4"
+
+// useAgentDir points the agent at a temporary directory for the test.
+func useAgentDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	location, statusPath := SALTOLocation, jsonFilePath
+	SALTOLocation, jsonFilePath = dir, filepath.Join(dir, "update_status.json")
+	t.Cleanup(func() { SALTOLocation, jsonFilePath = location, statusPath })
+	return dir
+}
+
+// recordSpans installs a tracer provider recording the spans in memory.
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.NewProvider(sdktrace.NewSimpleSpanProcessor(exporter), "v2025.02.01-sha.0a1b2c3")
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// extractRelease extracts a valid release, the running test binary being the ELF binary of the service.
+func extractRelease(stagingPath string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	binary, err := os.ReadFile(executable)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(stagingPath, service), binary, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(stagingPath, "config"), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(stagingPath, "config", "general-service.yml"), []byte("port: 8080\n"), 0644)
+}
+
+func TestInstallRelease(t *testing.T) {
+	tests := []struct {
+		name    string
+		extract func(string) error
+		wantErr bool
+	}{
+		{
+			name:    "valid release",
+			extract: extractRelease,
+		},
+		{
+			name:    "no binary",
+			extract: func(string) error { return nil },
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := useAgentDir(t)
+			exporter := recordSpans(t)
+			if err := status.Update(jsonFilePath, func(s *status.Status) { s.UpdateAvailable = 1 }); err != nil {
+				t.Fatal(err)
+			}
+			release := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			ctx, span := tracing.Start(context.Background(), tracing.SpanUpdate)
+			err := installRelease(ctx, testVersion, release, tt.extract, logger)
+			span.End()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("installRelease() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			var extract tracetest.SpanStub
+			for _, s := range exporter.GetSpans() {
+				if s.Name == tracing.SpanExtract {
+					extract = s
+				}
+			}
+			if extract.Name == "" {
+				t.Fatalf("no %s span", tracing.SpanExtract)
+			}
+			if extract.Parent.SpanID() != span.SpanContext().SpanID() {
+				t.Errorf("%s span is not a child of the update span", tracing.SpanExtract)
+			}
+			wantStatus := codes.Unset
+			if tt.wantErr {
+				wantStatus = codes.Error
+			}
+			if extract.Status.Code != wantStatus {
+				t.Errorf("%s span status = %v, want %v", tracing.SpanExtract, extract.Status.Code, wantStatus)
+			}
+
+			versionPath := filepath.Join(dir, testVersion)
+			if tt.wantErr {
+				if _, err := os.Stat(versionPath); !os.IsNotExist(err) {
+					t.Errorf("invalid release installed in %s", versionPath)
+				}
+				return
+			}
+			if got := readReleaseMetadata(versionPath); !got.Equal(release) {
+				t.Errorf("release date = %s, want %s", got, release)
+			}
+			if s, err := status.Read(jsonFilePath); err != nil || s.UpdateAvailable != 0 {
+				t.Errorf("status = %+v, %v, want the update no longer available", s, err)
+			}
+		})
+	}
+}
+
+func TestInstallReleaseInvalidVersion(t *testing.T) {
+	dir := useAgentDir(t)
+	exporter := recordSpans(t)
+	err := installRelease(context.Background(), "../"+testVersion, time.Time{}, extractRelease, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err == nil {
+		t.Fatal("installRelease() accepted a version that is not a tag")
+	}
+	if spans := exporter.GetSpans(); len(spans) != 0 {
+		t.Errorf("%d spans recorded, want none", len(spans))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("agent directory = %v, want it untouched", entries)
+	}
+}